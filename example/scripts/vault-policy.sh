@@ -124,6 +124,15 @@ path "${path}/metadata/${van}/${zone}/links/*" {
 path "${path}/data/${van}/${zone}/links/*" {
   capabilities = ["read", "list"]
 }
+path "${path}/${van}/${zone}/sites/*" {
+  capabilities = ["create", "update", "read", "delete"]
+}
+path "${path}/data/${van}/${zone}/sites/*" {
+  capabilities = ["create", "update", "read"]
+}
+path "${path}/metadata/${van}/${zone}/sites/*" {
+  capabilities = ["delete"]
+}
 EOF
 }
 
@@ -138,7 +147,7 @@ path "${path}/${van}/${zone}/links/*" {
   capabilities = ["create", "update", "delete", "read", "list"]
 }
 path "${path}/metadata/${van}/${zone}/links/*" {
-  capabilities = ["create", "update", "delete", "read", "list"]
+  capabilities = ["create", "update", "patch", "delete", "read", "list"]
 }
 path "${path}/data/${van}/${zone}/links/*" {
   capabilities = ["create", "update", "delete", "read", "list"]
@@ -146,6 +155,29 @@ path "${path}/data/${van}/${zone}/links/*" {
 EOF
 }
 
+van_policy_def() {
+    cat << EOF
+path "${path}/${van}/" {
+  capabilities = ["list"]
+}
+path "${path}/metadata/${van}/" {
+  capabilities = ["list"]
+}
+path "${path}/${van}/+/sites/" {
+  capabilities = ["list"]
+}
+path "${path}/metadata/${van}/+/sites/" {
+  capabilities = ["list"]
+}
+path "${path}/${van}/policy" {
+  capabilities = ["read"]
+}
+path "${path}/data/${van}/policy" {
+  capabilities = ["read"]
+}
+EOF
+}
+
 contains_element() {
     local element=$1
     shift
@@ -158,6 +190,7 @@ contains_element() {
 
 main() {
     parse_args "$@"
+    van_policy_def
     for zone in "${!zones[@]}"; do
         IFS="," read -ra reachable_from_zones <<< "${zones[${zone}]}"
         consume_policy_def "${zone}"
@@ -4,8 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"sync"
 
+	"github.com/fgiorgetti/vanform/internal/metrics"
+	"github.com/fgiorgetti/vanform/internal/van"
 	vault "github.com/hashicorp/vault/api"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -26,27 +27,34 @@ func NewAppRole(secret *corev1.Secret) (*AppRole, error) {
 		return nil, fmt.Errorf("role-id not found in secret")
 	}
 	secretId, ok := secret.Data["secret-id"]
-	if !ok {
+	wrappedSecretId, wrapped := secret.Data["wrapped-secret-id"]
+	if !ok && !wrapped {
 		logger.Error("secret-id not found in secret", "name", secret.Name)
 		return nil, fmt.Errorf("secret-id not found in secret")
 	}
 	return &AppRole{
-		RoleId:         string(roleId),
-		SecretId:       string(secretId),
-		AuthMethodPath: string(path),
-		logger:         logger,
+		RoleId:          string(roleId),
+		SecretId:        string(secretId),
+		WrappedSecretId: string(wrappedSecretId),
+		AuthMethodPath:  string(path),
+		tokenRenewer: tokenRenewer{
+			logger: logger,
+		},
 	}, nil
 }
 
 type AppRole struct {
-	RoleId         string
-	SecretId       string
-	AuthMethodPath string
-	logger         *slog.Logger
-	mutex          sync.Mutex
-	loggedIn       bool
-	ctx            context.Context
-	cancel         context.CancelFunc
+	RoleId   string
+	SecretId string
+	// WrappedSecretId is a response-wrapping token holding the secret-id,
+	// it is only used when SecretId is empty
+	WrappedSecretId string
+	AuthMethodPath  string
+	// SecretIdUnwrapped is called to persist the secret-id obtained by
+	// unwrapping WrappedSecretId, as the wrapping token is single-use
+	SecretIdUnwrapped func(secretId string) error
+	secretIdPending   bool
+	tokenRenewer
 }
 
 func (a *AppRole) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
@@ -56,77 +64,78 @@ func (a *AppRole) Login(ctx context.Context, client *vault.Client) (*vault.Secre
 		a.logger = slog.Default()
 	}
 	// Stop existing renew routine
-	if a.cancel != nil {
-		a.cancel()
-	}
-	defaultStr := func(v, dflt string) string {
-		if v == "" {
-			return dflt
-		}
-		return v
+	a.stopRenew()
+	if err := a.unwrapSecretId(ctx, client); err != nil {
+		return nil, err
 	}
 	loginData := map[string]interface{}{
 		"role_id":   a.RoleId,
 		"secret_id": a.SecretId,
 	}
-	loginPath := fmt.Sprintf("auth/%s/login", defaultStr(a.AuthMethodPath, defaultAppRolePath))
+	loginPath := getLoginPath(a.AuthMethodPath, defaultAppRolePath)
 	a.logger.Debug("Logging in using approle", slog.String("path", loginPath))
 	secret, err := client.Logical().Write(loginPath, loginData)
+	metrics.ObserveLogin(van.AuthMethodAppRole, err)
 	if err != nil {
 		return nil, fmt.Errorf("unable to login: %v", err)
 	}
-	a.loggedIn = true
-	token := secret.Auth.ClientToken
-	client.SetToken(token)
-	a.ctx, a.cancel = context.WithCancel(ctx)
-	go a.renew(client, secret)
+	a.loggedInWith(ctx, client, secret)
 	return secret, nil
 }
 
-func (a *AppRole) renew(client *vault.Client, token *vault.Secret) {
-	if !token.Auth.Renewable {
-		a.logger.Warn("Token is not configured to be renewable.")
-		return
-	}
-	watcher, err := client.NewLifetimeWatcher(&vault.LifetimeWatcherInput{
-		Secret:    token,
-		Increment: 3600,
-	})
-	if err != nil {
-		a.logger.Error("unable to initialize new lifetime watcher for renewing auth token",
-			slog.Any("error", err))
-		return
+// unwrapSecretId must be called with the mutex held. It obtains the
+// secret-id from the wrapping token (if SecretId is not yet known) and
+// persists it through SecretIdUnwrapped.
+func (a *AppRole) unwrapSecretId(ctx context.Context, client *vault.Client) error {
+	if a.SecretId == "" {
+		if a.WrappedSecretId == "" {
+			return fmt.Errorf("secret-id not defined")
+		}
+		a.logger.Info("Unwrapping secret-id")
+		// using a clone as unwrapping with no token set replaces the client token
+		unwrapClient, err := client.CloneWithHeaders()
+		if err != nil {
+			return fmt.Errorf("unable to unwrap secret-id: %v", err)
+		}
+		unwrapClient.SetToken(a.WrappedSecretId)
+		secret, err := unwrapClient.Logical().UnwrapWithContext(ctx, "")
+		if err != nil {
+			return fmt.Errorf("unable to unwrap secret-id: %v", err)
+		}
+		if secret == nil || secret.Data == nil {
+			return fmt.Errorf("unable to unwrap secret-id: no data found")
+		}
+		secretId, ok := secret.Data["secret_id"].(string)
+		if !ok || secretId == "" {
+			return fmt.Errorf("unable to unwrap secret-id: secret_id not found")
+		}
+		a.SecretId = secretId
+		a.WrappedSecretId = ""
+		a.secretIdPending = true
 	}
-	go watcher.Start()
-	defer watcher.Stop()
+	// a failure is retried through PersistCredentials
+	_ = a.persistSecretId()
+	return nil
+}
 
-	for {
-		select {
-		// `DoneCh` will return if renewal fails, or if the remaining lease
-		// duration is under a built-in threshold, and either renewing is not
-		// extending it or renewing is disabled. In any case, the caller
-		// needs to attempt to log in again.
-		case err := <-watcher.DoneCh():
-			a.mutex.Lock()
-			a.loggedIn = false
-			a.mutex.Unlock()
-			if err != nil {
-				a.logger.Error("Failed to renew token", slog.Any("error", err))
-				return
-			}
-			// This occurs once the token has reached max TTL.
-			a.logger.Warn("Token can no longer be renewed.")
-			return
-		// Parent context is closed
-		case <-a.ctx.Done():
-			a.mutex.Lock()
-			a.logger.Warn("Context is canceled.")
-			a.loggedIn = false
-			a.mutex.Unlock()
-			return
-		// Successfully completed renewal
-		case renewal := <-watcher.RenewCh():
-			a.logger.Debug("Successfully renewed", slog.Any("at", renewal.RenewedAt))
-		}
+// PersistCredentials persists the unwrapped secret-id, if it could
+// not be persisted yet. Until then, it only exists in memory.
+func (a *AppRole) PersistCredentials() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.persistSecretId()
+}
+
+// persistSecretId must be called with the mutex held
+func (a *AppRole) persistSecretId() error {
+	if !a.secretIdPending || a.SecretIdUnwrapped == nil {
+		return nil
+	}
+	if err := a.SecretIdUnwrapped(a.SecretId); err != nil {
+		a.logger.Error("unable to persist unwrapped secret-id", slog.Any("error", err))
+		return fmt.Errorf("unable to persist unwrapped secret-id: %w", err)
 	}
+	a.logger.Info("Unwrapped secret-id has been persisted")
+	a.secretIdPending = false
+	return nil
 }
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/fgiorgetti/vanform/internal/metrics"
+	"github.com/fgiorgetti/vanform/internal/van"
+	vault "github.com/hashicorp/vault/api"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultKubernetesAuthPath      = "kubernetes"
+	defaultServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultServiceAccount          = "default"
+)
+
+// NewKubernetesAuth returns a KubernetesAuth using the role and mount path
+// defined in the credentials secret (if present) or in the VAN configuration.
+func NewKubernetesAuth(vanConfig *van.Config, secret *corev1.Secret) (*KubernetesAuth, error) {
+	logger := slog.Default()
+	role := vanConfig.AuthRole
+	path := vanConfig.AuthPath
+	if secret != nil {
+		logger = logger.With("namespace", secret.Namespace)
+		if secretRole, ok := secret.Data["kubernetes-role"]; ok {
+			role = string(secretRole)
+		}
+		if secretPath, ok := secret.Data["kubernetes-path"]; ok {
+			path = string(secretPath)
+		}
+	}
+	if role == "" {
+		logger.Error("kubernetes auth role not defined")
+		return nil, fmt.Errorf("kubernetes auth role not defined")
+	}
+	return &KubernetesAuth{
+		Role:           role,
+		AuthMethodPath: path,
+		TokenPath:      defaultServiceAccountTokenPath,
+		ServiceAccount: vanConfig.AuthServiceAccount,
+		tokenRenewer: tokenRenewer{
+			logger: logger,
+		},
+	}, nil
+}
+
+// JWTProvider returns a token of the given service account, used to log in
+// through the kubernetes auth method
+type JWTProvider func(ctx context.Context, serviceAccount string) (string, error)
+
+// KubernetesAuth logs in to Vault through the kubernetes auth method,
+// using the projected service account token of the running pod or, if
+// a JWTProvider is set, a token of the given ServiceAccount.
+type KubernetesAuth struct {
+	Role           string
+	AuthMethodPath string
+	TokenPath      string
+	ServiceAccount string
+	JWTProvider    JWTProvider
+	tokenRenewer
+}
+
+func (k *KubernetesAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.logger == nil {
+		k.logger = slog.Default()
+	}
+	// Stop existing renew routine
+	k.stopRenew()
+	jwt, err := k.getJWT(ctx)
+	if err != nil {
+		return nil, err
+	}
+	loginData := map[string]interface{}{
+		"role": k.Role,
+		"jwt":  jwt,
+	}
+	loginPath := getLoginPath(k.AuthMethodPath, defaultKubernetesAuthPath)
+	k.logger.Debug("Logging in using kubernetes auth", slog.String("path", loginPath), slog.String("role", k.Role))
+	secret, err := client.Logical().Write(loginPath, loginData)
+	metrics.ObserveLogin(van.AuthMethodKubernetes, err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to login: %v", err)
+	}
+	k.loggedInWith(ctx, client, secret)
+	return secret, nil
+}
+
+func (k *KubernetesAuth) getJWT(ctx context.Context) (string, error) {
+	if k.JWTProvider != nil {
+		serviceAccount := k.ServiceAccount
+		if serviceAccount == "" {
+			serviceAccount = defaultServiceAccount
+		}
+		jwt, err := k.JWTProvider(ctx, serviceAccount)
+		if err != nil {
+			return "", fmt.Errorf("unable to request token for service account %s: %v", serviceAccount, err)
+		}
+		return jwt, nil
+	}
+	// projected service account tokens are rotated, so it must be read on every login
+	tokenPath := k.TokenPath
+	if tokenPath == "" {
+		tokenPath = defaultServiceAccountTokenPath
+	}
+	jwt, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to read service account token from %s: %v", tokenPath, err)
+	}
+	return strings.TrimSpace(string(jwt)), nil
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type LifetimeEventType string
+
+const (
+	// LifetimeLoggedIn is emitted when a new auth token has been obtained
+	LifetimeLoggedIn LifetimeEventType = "LoggedIn"
+	// LifetimeRenewed is emitted when the auth token has been renewed
+	LifetimeRenewed LifetimeEventType = "Renewed"
+	// LifetimeRenewFailed is emitted when the auth token could not be renewed
+	LifetimeRenewFailed LifetimeEventType = "RenewFailed"
+	// LifetimeExpired is emitted when the auth token has reached its max TTL
+	LifetimeExpired LifetimeEventType = "Expired"
+	// LifetimeStopped is emitted when the renewal of the auth token is stopped
+	LifetimeStopped LifetimeEventType = "Stopped"
+)
+
+// LifetimeEvent describes a change to the lifetime of the auth token.
+type LifetimeEvent struct {
+	Type  LifetimeEventType
+	Time  time.Time
+	Error error
+}
+
+// LifetimeHandler is notified about lifetime events of the auth token.
+// Handlers are called synchronously, so they must not block.
+type LifetimeHandler func(event LifetimeEvent)
+
+// tokenRenewer keeps the auth token obtained by an auth method renewed
+// until it can no longer be renewed or the login context is canceled.
+type tokenRenewer struct {
+	logger   *slog.Logger
+	mutex    sync.Mutex
+	loggedIn bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	handlers []LifetimeHandler
+}
+
+// LoggedIn returns false if the auth token could no longer be renewed or if
+// the renew routine has been stopped, meaning that a new login is needed.
+func (r *tokenRenewer) LoggedIn() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.loggedIn
+}
+
+// Stop stops renewing the current auth token and flags it as
+// no longer valid, so that a new login is required.
+func (r *tokenRenewer) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stopRenew()
+	r.loggedIn = false
+}
+
+// AddLifetimeHandler registers a handler to be notified about
+// lifetime events of the auth token.
+func (r *tokenRenewer) AddLifetimeHandler(handler LifetimeHandler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.handlers = append(r.handlers, handler)
+}
+
+// notify must be called with the mutex held.
+func (r *tokenRenewer) notify(eventType LifetimeEventType, err error) {
+	event := LifetimeEvent{
+		Type:  eventType,
+		Time:  time.Now(),
+		Error: err,
+	}
+	for _, handler := range r.handlers {
+		handler(event)
+	}
+}
+
+// stopRenew must be called with the mutex held. It stops the renew
+// routine started for the previous auth token (if any).
+func (r *tokenRenewer) stopRenew() {
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+}
+
+// loggedInWith must be called with the mutex held, once a new auth
+// token has been obtained. It sets the token on the client and starts
+// the renew routine for it.
+func (r *tokenRenewer) loggedInWith(ctx context.Context, client *vault.Client, secret *vault.Secret) {
+	r.loggedIn = true
+	client.SetToken(secret.Auth.ClientToken)
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	r.notify(LifetimeLoggedIn, nil)
+	go r.renew(r.ctx, client, secret)
+}
+
+func (r *tokenRenewer) renew(ctx context.Context, client *vault.Client, token *vault.Secret) {
+	if !token.Auth.Renewable {
+		r.logger.Warn("Token is not configured to be renewable.")
+		return
+	}
+	watcher, err := client.NewLifetimeWatcher(&vault.LifetimeWatcherInput{
+		Secret:    token,
+		Increment: 3600,
+	})
+	if err != nil {
+		r.logger.Error("unable to initialize new lifetime watcher for renewing auth token",
+			slog.Any("error", err))
+		return
+	}
+	go watcher.Start()
+	defer watcher.Stop()
+
+	for {
+		select {
+		// `DoneCh` will return if renewal fails, or if the remaining lease
+		// duration is under a built-in threshold, and either renewing is not
+		// extending it or renewing is disabled. In any case, the caller
+		// needs to attempt to log in again.
+		case err := <-watcher.DoneCh():
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			if ctx.Err() != nil {
+				// a new login has already replaced this token
+				return
+			}
+			r.loggedIn = false
+			if err != nil {
+				r.logger.Error("Failed to renew token", slog.Any("error", err))
+				r.notify(LifetimeRenewFailed, err)
+				return
+			}
+			// This occurs once the token has reached max TTL.
+			r.logger.Warn("Token can no longer be renewed.")
+			r.notify(LifetimeExpired, nil)
+			return
+		// Parent context is closed (the login state is owned by whoever canceled it)
+		case <-ctx.Done():
+			r.mutex.Lock()
+			defer r.mutex.Unlock()
+			r.logger.Debug("Context is canceled.")
+			r.notify(LifetimeStopped, nil)
+			return
+		// Successfully completed renewal
+		case renewal := <-watcher.RenewCh():
+			r.logger.Debug("Successfully renewed", slog.Any("at", renewal.RenewedAt))
+			r.mutex.Lock()
+			r.notify(LifetimeRenewed, nil)
+			r.mutex.Unlock()
+		}
+	}
+}
+
+// getLoginPath returns the login path of the auth method mounted at
+// the given path (or at the default one if empty)
+func getLoginPath(authMethodPath, defaultAuthMethodPath string) string {
+	if authMethodPath == "" {
+		authMethodPath = defaultAuthMethodPath
+	}
+	return fmt.Sprintf("auth/%s/login", authMethodPath)
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fgiorgetti/vanform/internal/van"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NewTokenStore returns an authenticated van.TokenStore for the backend
+// defined in the provided VAN configuration (defaults to vault).
+// The saveSecret function is used to persist changes the store needs
+// to make to the credentials secret.
+func NewTokenStore(ctx context.Context, vanConfig *van.Config, secret *corev1.Secret, saveSecret van.SecretSaver) (van.TokenStore, error) {
+	return newTokenStore(ctx, vanConfig, secret, saveSecret, nil)
+}
+
+// NewTokenStoreWithJWT returns a NewTokenStore alternative whose kubernetes
+// auth logins use the service account tokens returned by the jwtProvider,
+// instead of the token of the running pod.
+func NewTokenStoreWithJWT(jwtProvider JWTProvider) func(ctx context.Context, vanConfig *van.Config, secret *corev1.Secret, saveSecret van.SecretSaver) (van.TokenStore, error) {
+	return func(ctx context.Context, vanConfig *van.Config, secret *corev1.Secret, saveSecret van.SecretSaver) (van.TokenStore, error) {
+		return newTokenStore(ctx, vanConfig, secret, saveSecret, jwtProvider)
+	}
+}
+
+func newTokenStore(ctx context.Context, vanConfig *van.Config, secret *corev1.Secret, saveSecret van.SecretSaver, jwtProvider JWTProvider) (van.TokenStore, error) {
+	switch vanConfig.Backend {
+	case "", van.BackendVault:
+		vault, err := newVaultClient(vanConfig, secret)
+		if err != nil {
+			return nil, err
+		}
+		if kubernetesAuth, ok := vault.auth.(*KubernetesAuth); ok {
+			kubernetesAuth.JWTProvider = jwtProvider
+		}
+		if appRole, ok := vault.auth.(*AppRole); ok && saveSecret != nil {
+			appRole.SecretIdUnwrapped = func(secretId string) error {
+				return saveSecret(unwrappedSecret(secret, secretId))
+			}
+		}
+		vault.AddLifetimeHandler(observeLifetime)
+		_, err = vault.Login(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("vault login has failed: %w", err)
+		}
+		return vault, nil
+	default:
+		return nil, fmt.Errorf("unsupported token store backend: %q", vanConfig.Backend)
+	}
+}
+
+func newVaultClient(vanConfig *van.Config, secret *corev1.Secret) (*Vault, error) {
+	switch vanConfig.AuthMethod {
+	case "", van.AuthMethodAppRole:
+		vault, err := NewAppRoleClient(vanConfig, secret)
+		if err != nil {
+			return nil, fmt.Errorf("error creating app role client: %w", err)
+		}
+		return vault, nil
+	case van.AuthMethodKubernetes:
+		vault, err := NewKubernetesAuthClient(vanConfig, secret)
+		if err != nil {
+			return nil, fmt.Errorf("error creating kubernetes auth client: %w", err)
+		}
+		return vault, nil
+	default:
+		return nil, fmt.Errorf("unsupported vault auth method: %q", vanConfig.AuthMethod)
+	}
+}
+
+// unwrappedSecret returns a copy of the credentials secret holding the
+// given secret-id in place of the wrapped-secret-id
+func unwrappedSecret(secret *corev1.Secret, secretId string) *corev1.Secret {
+	updated := secret.DeepCopy()
+	if updated.Data == nil {
+		updated.Data = map[string][]byte{}
+	}
+	updated.Data["secret-id"] = []byte(secretId)
+	delete(updated.Data, "wrapped-secret-id")
+	return updated
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	vault "github.com/hashicorp/vault/api"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// configureTLS applies the TLS settings found in the credentials secret
+// to the vault client configuration. The following keys are recognized:
+//
+//   - ca.crt: PEM-encoded CA bundle used to verify the Vault server
+//   - tls.crt and tls.key: PEM-encoded client certificate and key (mTLS)
+//   - tls-server-name: overrides the server name used for SNI and verification
+//   - tls-insecure: disables verification of the Vault server certificate
+func configureTLS(config *vault.Config, secret *corev1.Secret) error {
+	if secret == nil {
+		return nil
+	}
+	tlsConfig := &vault.TLSConfig{
+		CACertBytes:   secret.Data["ca.crt"],
+		TLSServerName: string(secret.Data["tls-server-name"]),
+	}
+	if insecure, ok := secret.Data["tls-insecure"]; ok {
+		var err error
+		tlsConfig.Insecure, err = strconv.ParseBool(string(insecure))
+		if err != nil {
+			return fmt.Errorf("invalid tls-insecure value %q: %v", string(insecure), err)
+		}
+	}
+	if err := config.ConfigureTLS(tlsConfig); err != nil {
+		return fmt.Errorf("error configuring vault tls: %v", err)
+	}
+
+	// the vault api only loads client certificates from files
+	clientCert, hasCert := secret.Data["tls.crt"]
+	clientKey, hasKey := secret.Data["tls.key"]
+	if !hasCert && !hasKey {
+		return nil
+	}
+	if !hasCert || !hasKey {
+		return fmt.Errorf("both tls.crt and tls.key must be provided")
+	}
+	cert, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		return fmt.Errorf("error loading vault client certificate: %v", err)
+	}
+	transport, ok := config.HttpClient.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("unsupported vault http transport type %T", config.HttpClient.Transport)
+	}
+	transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return &cert, nil
+	}
+	return nil
+}
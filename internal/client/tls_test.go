@@ -0,0 +1,117 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func newCertificate(t *testing.T) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Assert(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vanform"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Assert(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Assert(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestConfigureTLS(t *testing.T) {
+	certPEM, keyPEM := newCertificate(t)
+	tests := []struct {
+		name          string
+		data          map[string][]byte
+		transport     http.RoundTripper
+		expectedError string
+		verify        func(t *testing.T, tlsConfig *tls.Config)
+	}{
+		{
+			name: "defaults",
+			data: map[string][]byte{},
+			verify: func(t *testing.T, tlsConfig *tls.Config) {
+				assert.Assert(t, !tlsConfig.InsecureSkipVerify)
+				assert.Assert(t, tlsConfig.GetClientCertificate == nil)
+			},
+		},
+		{
+			name: "ca-and-server-name",
+			data: map[string][]byte{"ca.crt": certPEM, "tls-server-name": []byte("vault.internal")},
+			verify: func(t *testing.T, tlsConfig *tls.Config) {
+				assert.Assert(t, tlsConfig.RootCAs != nil)
+				assert.Equal(t, tlsConfig.ServerName, "vault.internal")
+			},
+		},
+		{
+			name: "insecure",
+			data: map[string][]byte{"tls-insecure": []byte("true")},
+			verify: func(t *testing.T, tlsConfig *tls.Config) {
+				assert.Assert(t, tlsConfig.InsecureSkipVerify)
+			},
+		},
+		{
+			name:          "invalid-insecure",
+			data:          map[string][]byte{"tls-insecure": []byte("maybe")},
+			expectedError: `invalid tls-insecure value "maybe"`,
+		},
+		{
+			name: "client-certificate",
+			data: map[string][]byte{"tls.crt": certPEM, "tls.key": keyPEM},
+			verify: func(t *testing.T, tlsConfig *tls.Config) {
+				assert.Assert(t, tlsConfig.GetClientCertificate != nil)
+				cert, err := tlsConfig.GetClientCertificate(&tls.CertificateRequestInfo{})
+				assert.Assert(t, err)
+				assert.Equal(t, len(cert.Certificate), 1)
+			},
+		},
+		{
+			name:          "certificate-without-key",
+			data:          map[string][]byte{"tls.crt": certPEM},
+			expectedError: "both tls.crt and tls.key must be provided",
+		},
+		{
+			name:          "key-without-certificate",
+			data:          map[string][]byte{"tls.key": keyPEM},
+			expectedError: "both tls.crt and tls.key must be provided",
+		},
+		{
+			name:          "unsupported-transport",
+			data:          map[string][]byte{"tls.crt": certPEM, "tls.key": keyPEM},
+			transport:     http.NewFileTransport(http.Dir(".")),
+			expectedError: "unsupported HTTPClient transport type",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := vault.DefaultConfig()
+			if test.transport != nil {
+				config.HttpClient.Transport = test.transport
+			}
+			err := configureTLS(config, &corev1.Secret{Data: test.data})
+			if test.expectedError != "" {
+				assert.ErrorContains(t, err, test.expectedError)
+				return
+			}
+			assert.Assert(t, err)
+			test.verify(t, config.HttpClient.Transport.(*http.Transport).TLSClientConfig)
+		})
+	}
+}
@@ -2,30 +2,71 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/fgiorgetti/vanform/internal/metrics"
 	"github.com/fgiorgetti/vanform/internal/van"
 	vault "github.com/hashicorp/vault/api"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/yaml"
 )
 
+// authMethod is a vault.AuthMethod that keeps the obtained token renewed
+// until it is stopped or the token can no longer be renewed.
+type authMethod interface {
+	vault.AuthMethod
+	LoggedIn() bool
+	Stop()
+	AddLifetimeHandler(handler LifetimeHandler)
+}
+
+const (
+	// publishedAtKey and heartbeatKey are stored as custom metadata (kv v2)
+	// or along with the token data (kv v1)
+	publishedAtKey = "published_at"
+	heartbeatKey   = "heartbeat"
+)
+
+// loginBackoff defines the retries performed when logging in again
+// after the auth token has expired or been revoked.
+var loginBackoff = wait.Backoff{
+	Steps:    5,
+	Duration: time.Second,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
 type Vault struct {
-	client *vault.Client
-	config *vault.Config
-	auth   vault.AuthMethod
-	van    *van.Config
-	logger *slog.Logger
+	client    *vault.Client
+	config    *vault.Config
+	auth      authMethod
+	van       *van.Config
+	kvVersion int
+	logger    *slog.Logger
 }
 
-func newClient(vanConfig *van.Config) (*Vault, error) {
+func newClient(vanConfig *van.Config, vaultConfig *corev1.Secret) (*Vault, error) {
 	config := vault.DefaultConfig()
 	config.Address = vanConfig.URL
+	if err := configureTLS(config, vaultConfig); err != nil {
+		return nil, err
+	}
 	client, err := vault.NewClient(config)
 	if err != nil {
 		return nil, fmt.Errorf("error creating vault client: %v", err)
 	}
+	// the namespace header applies to both the auth method login and KV calls
+	if vanConfig.VaultNamespace != "" {
+		client.SetNamespace(vanConfig.VaultNamespace)
+	}
 	v := &Vault{
 		client: client,
 		config: config,
@@ -42,7 +83,7 @@ func newClient(vanConfig *van.Config) (*Vault, error) {
 func NewAppRoleClient(vanConfig *van.Config, vaultConfig *corev1.Secret) (*Vault, error) {
 	var client *Vault
 	var err error
-	client, err = newClient(vanConfig)
+	client, err = newClient(vanConfig, vaultConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +94,20 @@ func NewAppRoleClient(vanConfig *van.Config, vaultConfig *corev1.Secret) (*Vault
 	return client, nil
 }
 
+func NewKubernetesAuthClient(vanConfig *van.Config, vaultConfig *corev1.Secret) (*Vault, error) {
+	var client *Vault
+	var err error
+	client, err = newClient(vanConfig, vaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	client.auth, err = NewKubernetesAuth(vanConfig, vaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
 func (v *Vault) Login(ctx context.Context) (*vault.Secret, error) {
 	if v.auth == nil {
 		return nil, fmt.Errorf("vault auth method not configured")
@@ -63,65 +118,235 @@ func (v *Vault) Login(ctx context.Context) (*vault.Secret, error) {
 		return nil, err
 	}
 
+	if v.kvVersion == 0 {
+		v.kvVersion, err = v.getKVVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return secret, nil
 }
 
-func (v *Vault) GetAvailableTokens(mySiteName string) ([]*van.Token, error) {
+// Close stops renewing the auth token of the current session and revokes it.
+func (v *Vault) Close() {
+	if v.auth != nil {
+		v.auth.Stop()
+	}
+	if v.client.Token() == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := v.client.Auth().Token().RevokeSelfWithContext(ctx, "")
+	observeRequest("revoke_self", start, err)
+	if err != nil {
+		v.logger.Warn("unable to revoke auth token", slog.Any("error", err))
+	}
+	v.client.ClearToken()
+}
+
+// PersistCredentials persists the credentials obtained while logging in
+// (i.e. an unwrapped secret-id) that could not be persisted yet.
+func (v *Vault) PersistCredentials() error {
+	if appRole, ok := v.auth.(*AppRole); ok {
+		return appRole.PersistCredentials()
+	}
+	return nil
+}
+
+// UpdateConfig replaces the VAN configuration of the store, keeping
+// the defaults set when the session was created.
+func (v *Vault) UpdateConfig(config *van.Config) {
+	updated := *config
+	updated.Path = v.van.Path
+	v.van = &updated
+}
+
+// AddLifetimeHandler registers a handler to be notified about lifetime
+// events (login, renewal, expiration) of the auth token.
+func (v *Vault) AddLifetimeHandler(handler LifetimeHandler) {
+	if v.auth != nil {
+		v.auth.AddLifetimeHandler(handler)
+	}
+}
+
+// ensureLoggedIn logs in again (with backoff) if the auth token has
+// expired or could no longer be renewed.
+func (v *Vault) ensureLoggedIn(ctx context.Context) error {
+	if v.auth == nil || v.auth.LoggedIn() {
+		return nil
+	}
+	v.logger.Info("Vault session is no longer valid, logging in again")
+	err := retry.OnError(loginBackoff, func(err error) bool {
+		v.logger.Warn("vault login has failed, retrying", slog.Any("error", err))
+		return ctx.Err() == nil
+	}, func() error {
+		_, err := v.Login(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("vault login has failed: %w", err)
+	}
+	return nil
+}
+
+// withLogin runs the given operation, ensuring the session is logged in.
+// If the operation is denied because the auth token has expired or has been
+// revoked, it logs in again and retries the operation once.
+func (v *Vault) withLogin(ctx context.Context, operation func() error) error {
+	if err := v.ensureLoggedIn(ctx); err != nil {
+		return err
+	}
+	err := operation()
+	if !isPermissionDenied(err) || v.auth == nil {
+		return err
+	}
+	// permission denied is also returned when policies do not grant access,
+	// in which case the auth token is still valid
+	if _, lookupErr := v.client.Auth().Token().LookupSelfWithContext(ctx); lookupErr == nil {
+		return err
+	}
+	v.logger.Warn("Vault auth token is no longer valid", slog.Any("error", err))
+	v.auth.Stop()
+	if err = v.ensureLoggedIn(ctx); err != nil {
+		return err
+	}
+	return operation()
+}
+
+func isCheckAndSetConflict(err error) bool {
+	var respErr *vault.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	for _, respErrMsg := range respErr.Errors {
+		if strings.Contains(respErrMsg, "check-and-set") {
+			return true
+		}
+	}
+	return false
+}
+
+func isPermissionDenied(err error) bool {
+	var respErr *vault.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden
+}
+
+// getKVVersion returns the KV version defined in the VAN configuration or,
+// if not defined, the version of the KV secrets engine mounted at the VAN path.
+// If the mount cannot be read, version 2 is assumed.
+func (v *Vault) getKVVersion(ctx context.Context) (int, error) {
+	switch v.van.KVVersion {
+	case 1, 2:
+		return v.van.KVVersion, nil
+	case 0:
+	default:
+		return 0, fmt.Errorf("invalid kv_version: %d", v.van.KVVersion)
+	}
+	logger := v.logger.With(slog.String("mount", v.van.Path))
+	// the preflight endpoint used by the vault CLI, readable by any
+	// token granted access to the mount (unlike sys/mounts)
+	start := time.Now()
+	mount, err := v.client.Logical().ReadWithContext(ctx, "sys/internal/ui/mounts/"+v.van.Path)
+	observeRequest("get_mount", start, err)
+	if err != nil || mount == nil {
+		logger.Error("unable to detect kv version, assuming version 2 (set kv_version to define it)", slog.Any("error", err))
+		return 2, nil
+	}
+	kvVersion := 1
+	if options, ok := mount.Data["options"].(map[string]interface{}); ok && options["version"] == "2" {
+		kvVersion = 2
+	}
+	logger.Debug("kv version detected", slog.Int("version", kvVersion))
+	return kvVersion, nil
+}
+
+// GetAvailableTokens returns the tokens published to the configured zones,
+// along with the zones whose tokens could not be (completely) retrieved.
+func (v *Vault) GetAvailableTokens() ([]*van.Token, []string, error) {
 	var tokens []*van.Token
+	var failedZones []string
+	tokenTTL, err := v.van.GetTokenTTL()
+	if err != nil {
+		return nil, nil, err
+	}
 	for _, zone := range v.van.Zones {
-		availableLinksPath := v.getLogicalLinksListPath(zone.Name)
-		logger := v.logger.With("zone", zone.Name).With("path", availableLinksPath)
-		logger.Debug("getting available links")
-		links, err := v.client.Logical().List(availableLinksPath)
+		zoneTokens, err := v.getZoneTokens(zone.Name, tokenTTL)
 		if err != nil {
-			logger.Error("unable to get links list", slog.Any("error", err))
-			continue
+			v.logger.Error("unable to retrieve all links", slog.String("zone", zone.Name), slog.Any("error", err))
+			failedZones = append(failedZones, zone.Name)
 		}
-		if links == nil {
-			logger.Debug("no links found")
+		tokens = append(tokens, zoneTokens...)
+	}
+	return tokens, failedZones, nil
+}
+
+// getZoneTokens returns the tokens published to the given zone. If an error
+// occurs, the tokens retrieved so far are returned along with the error.
+func (v *Vault) getZoneTokens(zoneName string, tokenTTL time.Duration) ([]*van.Token, error) {
+	var tokens []*van.Token
+	var errs []error
+	availableLinksPath := v.getLogicalLinksListPath(zoneName)
+	logger := v.logger.With("zone", zoneName).With("path", availableLinksPath)
+	logger.Debug("getting available links")
+	keys, err := v.listKeys(context.Background(), availableLinksPath)
+	if err != nil {
+		logger.Error("unable to get links list", slog.Any("error", err))
+		return nil, fmt.Errorf("unable to get links list from %s: %v", availableLinksPath, err)
+	}
+	if len(keys) == 0 {
+		logger.Debug("no links found")
+		return nil, nil
+	}
+
+	for _, key := range keys {
+		linkPath := v.getLinkGetPath(zoneName, key)
+		logger := v.logger.With(
+			slog.String("zone", zoneName),
+			slog.String("van", v.van.VAN),
+			slog.String("mount", v.van.Path),
+			slog.String("path", linkPath),
+		)
+		logger.Debug("getting link")
+
+		secret, err := v.kvGet(context.Background(), linkPath)
+		if err != nil {
+			if errors.Is(err, vault.ErrSecretNotFound) {
+				logger.Debug("link not found - possibly deleted")
+				continue
+			}
+			logger.Error("error getting link", slog.Any("error", err))
+			errs = append(errs, fmt.Errorf("error getting link from %s at %s: %v", v.van.Path, linkPath, err))
 			continue
 		}
-		keys, ok := links.Data["keys"]
+		tokenStr, ok := secret.Data["token"]
 		if !ok {
-			logger.Debug("no links found", slog.String("path", availableLinksPath))
+			logger.Debug("token key not found - possibly deleted")
 			continue
 		}
-
-		for _, key := range keys.([]interface{}) {
-			linkPath := v.getLinkGetPath(zone.Name, key.(string))
-			logger := v.logger.With(
-				slog.String("zone", zone.Name),
-				slog.String("van", v.van.VAN),
-				slog.String("mount", v.van.Path),
-				slog.String("path", linkPath),
-			)
-			logger.Debug("getting link")
-
-			secret, err := v.client.KVv2(v.van.Path).Get(context.Background(), linkPath)
-			if err != nil {
-				logger.Error("error getting link", slog.Any("error", err))
-				return nil, fmt.Errorf("error getting link from %s at %s: %v", v.van.Path, linkPath, err)
-			}
-			tokenStr, ok := secret.Data["token"]
-			if !ok {
-				logger.Debug("token key not found - possibly deleted")
-				continue
-			}
-			var token = new(van.Token)
-			err = token.Unmarshal(tokenStr.(string))
-			if err != nil {
-				logger.Error("error unmarshalling token", slog.Any("error", err))
-				return nil, fmt.Errorf("error unmarshalling token from %s at %s: %v", v.van.Path, availableLinksPath, err)
-			}
-			if token.SiteName == mySiteName {
-				logger.Debug("ignoring self-token")
-				continue
+		var token = new(van.Token)
+		err = token.Unmarshal(tokenStr.(string))
+		if err != nil {
+			logger.Error("error unmarshalling token", slog.Any("error", err))
+			errs = append(errs, fmt.Errorf("error unmarshalling token from %s at %s: %v", v.van.Path, linkPath, err))
+			continue
+		}
+		token.Heartbeat, _ = v.getHeartbeat(secret)
+		if token.Expired(tokenTTL) {
+			logger.Info("ignoring expired token", slog.Time("heartbeat", token.Heartbeat))
+			if v.van.ReapExpiredTokens {
+				if err = v.kvDelete(context.Background(), linkPath); err != nil {
+					logger.Warn("unable to reap expired token", slog.Any("error", err))
+				}
 			}
-			logger.Debug("link found", slog.Any("token", token))
-			tokens = append(tokens, token)
+			continue
 		}
+		logger.Debug("link found", slog.Any("token", token))
+		tokens = append(tokens, token)
 	}
-	return tokens, nil
+	return tokens, errors.Join(errs...)
 }
 
 func (v *Vault) PublishToken(token van.Token) error {
@@ -136,13 +361,33 @@ func (v *Vault) PublishToken(token van.Token) error {
 		slog.String("mount", v.van.Path),
 		slog.String("path", publishPath),
 	)
-	_, err = v.client.KVv2(v.van.Path).Put(context.Background(), publishPath, map[string]interface{}{
+	now := time.Now().UTC().Format(time.RFC3339)
+	data := map[string]interface{}{
 		"token": string(tokenYaml),
-	})
+	}
+	if v.kvVersion == 1 {
+		data[publishedAtKey] = now
+		data[heartbeatKey] = now
+	}
+	err = v.kvPut(context.Background(), publishPath, data, vault.WithCheckAndSet(token.Version))
 	if err != nil {
+		if isCheckAndSetConflict(err) {
+			logger.Warn("published token has been modified", slog.String("site", token.SiteName), slog.String("target", token.TargetZone))
+			return fmt.Errorf("error publishing token at %s: %w", publishPath, van.ErrTokenConflict)
+		}
 		logger.Error("error publishing token", slog.String("site", token.SiteName), slog.String("target", token.TargetZone))
 		return fmt.Errorf("error publishing token: %v", err)
 	}
+	if v.kvVersion != 1 {
+		err = v.kvPatchMetadata(context.Background(), publishPath, map[string]interface{}{
+			publishedAtKey: now,
+			heartbeatKey:   now,
+		})
+		if err != nil {
+			logger.Error("error setting published token metadata", slog.Any("error", err))
+			return fmt.Errorf("error setting published token metadata: %v", err)
+		}
+	}
 	logger.Info("token published", slog.String("site", token.SiteName), slog.String("target", token.TargetZone))
 	return nil
 }
@@ -159,7 +404,7 @@ func (v *Vault) GetPublishedToken(siteName, sourceZone, targetZone string) (*van
 		slog.String("path", linkPath),
 	)
 	logger.Debug("getting link")
-	secret, err := v.client.KVv2(v.van.Path).Get(context.Background(), linkPath)
+	secret, err := v.kvGet(context.Background(), linkPath)
 	if err != nil {
 		if !strings.Contains(err.Error(), "not found") {
 			logger.Error("error getting link", slog.Any("error", err))
@@ -168,6 +413,17 @@ func (v *Vault) GetPublishedToken(siteName, sourceZone, targetZone string) (*van
 		logger.Debug("no published link found")
 		return nil, nil
 	}
+	if secret.Data == nil && secret.VersionMetadata != nil {
+		// soft deleted, the current version must be replaced on publish
+		logger.Debug("published link has been deleted", slog.Int("version", secret.VersionMetadata.Version))
+		return &van.Token{
+			SiteName:   siteName,
+			SiteZone:   sourceZone,
+			TargetZone: targetZone,
+			Version:    secret.VersionMetadata.Version,
+			Deleted:    true,
+		}, nil
+	}
 	tokenStr, ok := secret.Data["token"]
 	if !ok {
 		logger.Error("token key not found")
@@ -180,11 +436,414 @@ func (v *Vault) GetPublishedToken(siteName, sourceZone, targetZone string) (*van
 		logger.Error("error unmarshalling token", slog.Any("error", err))
 		return nil, fmt.Errorf("error unmarshalling token from %s at %s: %v", v.van.Path, linkPath, err)
 	}
+	if secret.VersionMetadata != nil {
+		token.Version = secret.VersionMetadata.Version
+	}
+	token.Heartbeat, _ = v.getHeartbeat(secret)
 	logger.Debug("link found", slog.Any("token", token))
 	return token, nil
 }
 
+// RefreshPublishedToken updates the heartbeat of the token published by
+// the given site, from the source zone to the target zone.
+func (v *Vault) RefreshPublishedToken(siteName, sourceZone, targetZone string) error {
+	linkPath := v.getLinkGetPath(targetZone, v.getLinkKey(siteName, sourceZone))
+	logger := v.logger.With(
+		slog.String("sourceZone", sourceZone),
+		slog.String("targetZone", targetZone),
+		slog.String("van", v.van.VAN),
+		slog.String("mount", v.van.Path),
+		slog.String("path", linkPath),
+	)
+	now := time.Now().UTC().Format(time.RFC3339)
+	var err error
+	if v.kvVersion == 1 {
+		var secret *vault.KVSecret
+		secret, err = v.kvGet(context.Background(), linkPath)
+		if err == nil {
+			secret.Data[heartbeatKey] = now
+			err = v.kvPut(context.Background(), linkPath, secret.Data)
+		}
+	} else {
+		err = v.kvPatchMetadata(context.Background(), linkPath, map[string]interface{}{
+			heartbeatKey: now,
+		})
+	}
+	if err != nil {
+		logger.Error("error refreshing published token heartbeat", slog.Any("error", err))
+		return fmt.Errorf("error refreshing published token heartbeat at %s: %v", linkPath, err)
+	}
+	logger.Debug("published token heartbeat refreshed")
+	return nil
+}
+
+// getHeartbeat returns the last heartbeat of a published token, if any
+func (v *Vault) getHeartbeat(secret *vault.KVSecret) (time.Time, bool) {
+	values := secret.CustomMetadata
+	if v.kvVersion == 1 {
+		values = secret.Data
+	}
+	heartbeatStr, ok := values[heartbeatKey].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	heartbeat, err := time.Parse(time.RFC3339, heartbeatStr)
+	if err != nil {
+		v.logger.Debug("invalid token heartbeat", slog.String("heartbeat", heartbeatStr), slog.Any("error", err))
+		return time.Time{}, false
+	}
+	return heartbeat, true
+}
+
+// RegisterZones writes a marker for the given site under each zone, at
+// <path>/<van>/<zone>/sites/<siteName>, unless it is already present.
+func (v *Vault) RegisterZones(siteName string, zones []string) error {
+	var errs []error
+	for _, zone := range zones {
+		markerPath := v.getSiteMarkerPath(zone, siteName)
+		logger := v.logger.With(slog.String("zone", zone), slog.String("path", markerPath))
+		secret, err := v.kvGet(context.Background(), markerPath)
+		if err == nil && secret.Data != nil {
+			continue
+		}
+		if err != nil && !errors.Is(err, vault.ErrSecretNotFound) {
+			logger.Error("unable to read zone marker", slog.Any("error", err))
+			errs = append(errs, fmt.Errorf("unable to read zone marker at %s: %v", markerPath, err))
+			continue
+		}
+		err = v.kvPut(context.Background(), markerPath, map[string]interface{}{
+			"site":          siteName,
+			"registered_at": time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			logger.Error("unable to register zone", slog.Any("error", err))
+			errs = append(errs, fmt.Errorf("unable to register zone %s at %s: %v", zone, markerPath, err))
+			continue
+		}
+		logger.Info("zone registered")
+	}
+	return errors.Join(errs...)
+}
+
+// PruneZones removes the markers of the given site from the zones
+// it no longer belongs to (other than the given ones).
+func (v *Vault) PruneZones(siteName string, zones []string) error {
+	allZones, err := v.listZoneFolders()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, zone := range allZones {
+		if slices.Contains(zones, zone) {
+			continue
+		}
+		sitesPath := v.getLogicalSitesListPath(zone)
+		sites, err := v.listGrantedKeys(context.Background(), sitesPath)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to list sites at %s: %v", sitesPath, err))
+			continue
+		}
+		if !slices.Contains(sites, siteName) {
+			continue
+		}
+		markerPath := v.getSiteMarkerPath(zone, siteName)
+		if err = v.kvDelete(context.Background(), markerPath); err != nil {
+			v.logger.Error("unable to unregister zone", slog.String("zone", zone), slog.String("path", markerPath), slog.Any("error", err))
+			errs = append(errs, fmt.Errorf("unable to unregister zone %s at %s: %v", zone, markerPath, err))
+			continue
+		}
+		v.logger.Info("zone unregistered", slog.String("zone", zone), slog.String("path", markerPath))
+	}
+	return errors.Join(errs...)
+}
+
+// ListZones returns the zones that have sites registered in them
+func (v *Vault) ListZones() ([]string, error) {
+	allZones, err := v.listZoneFolders()
+	if err != nil {
+		return nil, err
+	}
+	var zones []string
+	for _, zone := range allZones {
+		sitesPath := v.getLogicalSitesListPath(zone)
+		sites, err := v.listGrantedKeys(context.Background(), sitesPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list sites at %s: %v", sitesPath, err)
+		}
+		if len(sites) > 0 {
+			zones = append(zones, zone)
+		}
+	}
+	return zones, nil
+}
+
+// listZoneFolders returns the zones found under the VAN path, present
+// once a site has registered in them or a token has been published to them
+func (v *Vault) listZoneFolders() ([]string, error) {
+	vanPath := v.getLogicalVanListPath()
+	keys, err := v.listKeys(context.Background(), vanPath)
+	if err != nil {
+		v.logger.Error("unable to list zones", slog.String("path", vanPath), slog.Any("error", err))
+		return nil, fmt.Errorf("unable to list zones at %s: %v", vanPath, err)
+	}
+	var zones []string
+	for _, key := range keys {
+		// zones are folders, other keys are ignored
+		if zone, ok := strings.CutSuffix(key, "/"); ok {
+			zones = append(zones, zone)
+		}
+	}
+	return zones, nil
+}
+
+// GetPolicy returns the VAN policy stored under the "policy" key at
+// <path>/<van>/policy, or nil if there is none
+func (v *Vault) GetPolicy() (*van.Policy, error) {
+	policyPath := v.getPolicyPath()
+	logger := v.logger.With(
+		slog.String("van", v.van.VAN),
+		slog.String("mount", v.van.Path),
+		slog.String("path", policyPath),
+	)
+	secret, err := v.kvGet(context.Background(), policyPath)
+	if err != nil {
+		if errors.Is(err, vault.ErrSecretNotFound) {
+			logger.Debug("no VAN policy found")
+			return nil, nil
+		}
+		if isPermissionDenied(err) {
+			logger.Warn("VAN policy cannot be read", slog.Any("error", err))
+			return nil, fmt.Errorf("%w at %s: %v", van.ErrPolicyDenied, policyPath, err)
+		}
+		logger.Error("error getting VAN policy", slog.Any("error", err))
+		return nil, fmt.Errorf("error getting VAN policy from %s at %s: %v", v.van.Path, policyPath, err)
+	}
+	policyStr, ok := secret.Data["policy"].(string)
+	if !ok {
+		return nil, fmt.Errorf("policy key not found for VAN %s at %s", v.van.VAN, policyPath)
+	}
+	policy := new(van.Policy)
+	if err = yaml.Unmarshal([]byte(policyStr), policy); err != nil {
+		return nil, fmt.Errorf("error unmarshalling VAN policy from %s at %s: %v", v.van.Path, policyPath, err)
+	}
+	if err = policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid VAN policy at %s: %w", policyPath, err)
+	}
+	return policy, nil
+}
+
+// ListPublishedTokens returns all tokens published by the given site,
+// across all target zones found under the VAN path.
+func (v *Vault) ListPublishedTokens(siteName string) ([]*van.Token, error) {
+	logger := v.logger.With(
+		slog.String("van", v.van.VAN),
+		slog.String("mount", v.van.Path),
+		slog.String("siteName", siteName),
+	)
+	logger.Debug("listing published tokens")
+	targetZones, err := v.listZoneFolders()
+	if err != nil {
+		return nil, err
+	}
+	var tokens []*van.Token
+	for _, targetZone := range targetZones {
+		linksPath := v.getLogicalLinksListPath(targetZone)
+		// policies may not grant access to zones this site does not publish to
+		keys, err := v.listGrantedKeys(context.Background(), linksPath)
+		if err != nil {
+			logger.Warn("unable to list links", slog.String("path", linksPath), slog.Any("error", err))
+			continue
+		}
+		for _, key := range keys {
+			// link keys are composed as <zone>-<siteName>
+			if !strings.HasSuffix(key, "-"+siteName) {
+				continue
+			}
+			sourceZone := strings.TrimSuffix(key, "-"+siteName)
+			token, err := v.GetPublishedToken(siteName, sourceZone, targetZone)
+			if err != nil {
+				// one unreadable token must not prevent the others from being listed
+				logger.Warn("skipping published token", slog.String("key", key), slog.String("targetZone", targetZone), slog.Any("error", err))
+				continue
+			}
+			if token == nil || token.Deleted || token.SiteName != siteName {
+				continue
+			}
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// DeletePublishedToken permanently removes the token published by the given
+// site, from the source zone to the target zone.
+func (v *Vault) DeletePublishedToken(siteName, sourceZone, targetZone string) error {
+	linkPath := v.getLinkGetPath(targetZone, v.getLinkKey(siteName, sourceZone))
+	logger := v.logger.With(
+		slog.String("sourceZone", sourceZone),
+		slog.String("targetZone", targetZone),
+		slog.String("van", v.van.VAN),
+		slog.String("mount", v.van.Path),
+		slog.String("path", linkPath),
+	)
+	err := v.kvDelete(context.Background(), linkPath)
+	if err != nil {
+		logger.Error("error deleting published token", slog.Any("error", err))
+		return fmt.Errorf("error deleting published token at %s: %v", linkPath, err)
+	}
+	logger.Info("published token deleted", slog.String("site", siteName))
+	return nil
+}
+
+// listKeys returns the keys found at the given logical path
+func (v *Vault) listKeys(ctx context.Context, path string) ([]string, error) {
+	secret, err := v.list(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return getKeys(secret), nil
+}
+
+// listGrantedKeys lists the keys at the given path, returning no keys if
+// policies do not grant access to it. As that is expected, the auth token is
+// not verified and the request is not counted as failed.
+func (v *Vault) listGrantedKeys(ctx context.Context, path string) ([]string, error) {
+	if err := v.ensureLoggedIn(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	secret, err := v.client.Logical().ListWithContext(ctx, path)
+	if isPermissionDenied(err) {
+		observeRequest("list", start, nil)
+		return nil, nil
+	}
+	observeRequest("list", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return getKeys(secret), nil
+}
+
+func getKeys(secret *vault.Secret) []string {
+	if secret == nil {
+		return nil
+	}
+	keys, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil
+	}
+	var keyList []string
+	for _, key := range keys {
+		if keyStr, ok := key.(string); ok {
+			keyList = append(keyList, keyStr)
+		}
+	}
+	return keyList
+}
+
+func (v *Vault) list(ctx context.Context, path string) (*vault.Secret, error) {
+	var secret *vault.Secret
+	err := v.withLogin(ctx, func() error {
+		var err error
+		start := time.Now()
+		secret, err = v.client.Logical().ListWithContext(ctx, path)
+		observeRequest("list", start, err)
+		return err
+	})
+	return secret, err
+}
+
+func (v *Vault) kvGet(ctx context.Context, path string) (*vault.KVSecret, error) {
+	var secret *vault.KVSecret
+	err := v.withLogin(ctx, func() error {
+		var err error
+		start := time.Now()
+		if v.kvVersion == 1 {
+			secret, err = v.client.KVv1(v.van.Path).Get(ctx, path)
+		} else {
+			secret, err = v.client.KVv2(v.van.Path).Get(ctx, path)
+		}
+		observeRequest("kv_get", start, err)
+		return err
+	})
+	return secret, err
+}
+
+// kvPut writes data to the given path, the options are only applied to kv v2
+func (v *Vault) kvPut(ctx context.Context, path string, data map[string]interface{}, opts ...vault.KVOption) error {
+	return v.withLogin(ctx, func() error {
+		var err error
+		start := time.Now()
+		if v.kvVersion == 1 {
+			err = v.client.KVv1(v.van.Path).Put(ctx, path, data)
+		} else {
+			_, err = v.client.KVv2(v.van.Path).Put(ctx, path, data, opts...)
+		}
+		observeRequest("kv_put", start, err)
+		return err
+	})
+}
+
+func (v *Vault) kvPatchMetadata(ctx context.Context, path string, customMetadata map[string]interface{}) error {
+	return v.withLogin(ctx, func() error {
+		start := time.Now()
+		err := v.client.KVv2(v.van.Path).PatchMetadata(ctx, path, vault.KVMetadataPatchInput{
+			CustomMetadata: customMetadata,
+		})
+		observeRequest("kv_patch_metadata", start, err)
+		return err
+	})
+}
+
+func (v *Vault) kvDelete(ctx context.Context, path string) error {
+	return v.withLogin(ctx, func() error {
+		var err error
+		start := time.Now()
+		if v.kvVersion == 1 {
+			err = v.client.KVv1(v.van.Path).Delete(ctx, path)
+		} else {
+			// removing all versions, so the key is no longer listed
+			err = v.client.KVv2(v.van.Path).DeleteMetadata(ctx, path)
+		}
+		observeRequest("kv_delete", start, err)
+		return err
+	})
+}
+
+// observeRequest records the metrics of a Vault request, secrets not
+// found are not accounted as errors
+func observeRequest(operation string, start time.Time, err error) {
+	if errors.Is(err, vault.ErrSecretNotFound) {
+		err = nil
+	}
+	metrics.ObserveVaultRequest(operation, start, err)
+}
+
+// observeLifetime records the renewals of the auth token
+func observeLifetime(event LifetimeEvent) {
+	switch event.Type {
+	case LifetimeRenewed:
+		metrics.ObserveRenewal(nil)
+	case LifetimeRenewFailed:
+		metrics.ObserveRenewal(event.Error)
+	}
+}
+
+func (v *Vault) getLogicalVanListPath() string {
+	if v.kvVersion == 1 {
+		return fmt.Sprintf("%s/%s", v.van.Path, v.van.VAN)
+	}
+	return fmt.Sprintf("%s/metadata/%s", v.van.Path, v.van.VAN)
+}
+
+func (v *Vault) getPolicyPath() string {
+	return fmt.Sprintf("%s/policy", v.van.VAN)
+}
+
 func (v *Vault) getLogicalLinksListPath(targetZone string) string {
+	if v.kvVersion == 1 {
+		return fmt.Sprintf("%s/%s/%s/links", v.van.Path, v.van.VAN, targetZone)
+	}
 	return fmt.Sprintf("%s/metadata/%s/%s/links", v.van.Path, v.van.VAN, targetZone)
 }
 
@@ -196,6 +855,17 @@ func (v *Vault) getLinksPutPath(siteName string, sourceZone string, targetZone s
 	return fmt.Sprintf("%s/%s/links/%s", v.van.VAN, targetZone, v.getLinkKey(siteName, sourceZone))
 }
 
+func (v *Vault) getLogicalSitesListPath(zone string) string {
+	if v.kvVersion == 1 {
+		return fmt.Sprintf("%s/%s/%s/sites", v.van.Path, v.van.VAN, zone)
+	}
+	return fmt.Sprintf("%s/metadata/%s/%s/sites", v.van.Path, v.van.VAN, zone)
+}
+
+func (v *Vault) getSiteMarkerPath(zone, siteName string) string {
+	return fmt.Sprintf("%s/%s/sites/%s", v.van.VAN, zone, siteName)
+}
+
 func (v *Vault) getLinkKey(siteName string, sourceZone string) string {
 	return fmt.Sprintf("%s-%s", sourceZone, siteName)
 }
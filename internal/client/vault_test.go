@@ -0,0 +1,67 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+	"gotest.tools/v3/assert"
+)
+
+func TestIsCheckAndSetConflict(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		conflict bool
+	}{
+		{name: "nil", err: nil},
+		{
+			name:     "cas-mismatch",
+			err:      &vault.ResponseError{StatusCode: http.StatusBadRequest, Errors: []string{"check-and-set parameter did not match the current version"}},
+			conflict: true,
+		},
+		{
+			name:     "wrapped",
+			err:      fmt.Errorf("error writing: %w", &vault.ResponseError{StatusCode: http.StatusBadRequest, Errors: []string{"check-and-set parameter required for this call"}}),
+			conflict: true,
+		},
+		{
+			name: "other-bad-request",
+			err:  &vault.ResponseError{StatusCode: http.StatusBadRequest, Errors: []string{"invalid path"}},
+		},
+		{
+			name: "other-status",
+			err:  &vault.ResponseError{StatusCode: http.StatusInternalServerError, Errors: []string{"check-and-set"}},
+		},
+		{name: "not-a-response-error", err: fmt.Errorf("check-and-set")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, isCheckAndSetConflict(test.err), test.conflict)
+		})
+	}
+}
+
+func TestIsPermissionDenied(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		denied bool
+	}{
+		{name: "nil", err: nil},
+		{name: "forbidden", err: &vault.ResponseError{StatusCode: http.StatusForbidden}, denied: true},
+		{
+			name:   "wrapped",
+			err:    fmt.Errorf("error listing: %w", &vault.ResponseError{StatusCode: http.StatusForbidden}),
+			denied: true,
+		},
+		{name: "not-found", err: &vault.ResponseError{StatusCode: http.StatusNotFound}},
+		{name: "not-a-response-error", err: fmt.Errorf("permission denied")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, isPermissionDenied(test.err), test.denied)
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsNamespace = "vanform"
+)
+
+var (
+	// Registry holds the VanForm metrics, along with the Go runtime and process ones
+	Registry = prometheus.NewRegistry()
+
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_total",
+		Help:      "Number of reconciliations per namespace",
+	}, []string{"namespace"})
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Number of failed reconciliations per namespace",
+	}, []string{"namespace"})
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of reconciliations per namespace",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"namespace"})
+	autoVanLinks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "auto_van_links",
+		Help:      "Number of auto-van Links per namespace",
+	}, []string{"namespace"})
+
+	tokensPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tokens_published_total",
+		Help:      "Number of tokens published, per site zone and target zone",
+	}, []string{"site_zone", "target_zone"})
+	tokensConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tokens_consumed_total",
+		Help:      "Number of tokens consumed (Links created or updated), per site zone and target zone",
+	}, []string{"site_zone", "target_zone"})
+	tokensDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tokens_deleted_total",
+		Help:      "Number of stale published tokens deleted, per site zone and target zone",
+	}, []string{"site_zone", "target_zone"})
+
+	vaultRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "vault_request_duration_seconds",
+		Help:      "Duration of Vault requests per operation",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+	vaultRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "vault_request_errors_total",
+		Help:      "Number of failed Vault requests per operation",
+	}, []string{"operation"})
+	vaultLogins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "vault_logins_total",
+		Help:      "Number of Vault logins per auth method and result",
+	}, []string{"auth_method", "result"})
+	vaultRenewals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "vault_renewals_total",
+		Help:      "Number of Vault auth token renewals per result",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		reconcileTotal,
+		reconcileErrors,
+		reconcileDuration,
+		autoVanLinks,
+		tokensPublished,
+		tokensConsumed,
+		tokensDeleted,
+		vaultRequestDuration,
+		vaultRequestErrors,
+		vaultLogins,
+		vaultRenewals,
+	)
+}
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// ObserveReconcile records a reconciliation of the namespace started at start
+func ObserveReconcile(namespace string, start time.Time, err error) {
+	reconcileTotal.WithLabelValues(namespace).Inc()
+	reconcileDuration.WithLabelValues(namespace).Observe(time.Since(start).Seconds())
+	if err != nil {
+		reconcileErrors.WithLabelValues(namespace).Inc()
+	}
+}
+
+func SetAutoVanLinks(namespace string, count int) {
+	autoVanLinks.WithLabelValues(namespace).Set(float64(count))
+}
+
+// DeleteNamespace removes the metrics of a namespace no longer reconciled
+func DeleteNamespace(namespace string) {
+	for _, vec := range []*prometheus.MetricVec{reconcileTotal.MetricVec, reconcileErrors.MetricVec, reconcileDuration.MetricVec, autoVanLinks.MetricVec} {
+		vec.DeleteLabelValues(namespace)
+	}
+}
+
+func TokenPublished(siteZone, targetZone string) {
+	tokensPublished.WithLabelValues(siteZone, targetZone).Inc()
+}
+
+func TokenConsumed(siteZone, targetZone string) {
+	tokensConsumed.WithLabelValues(siteZone, targetZone).Inc()
+}
+
+func TokenDeleted(siteZone, targetZone string) {
+	tokensDeleted.WithLabelValues(siteZone, targetZone).Inc()
+}
+
+// ObserveVaultRequest records a Vault request started at start
+func ObserveVaultRequest(operation string, start time.Time, err error) {
+	vaultRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		vaultRequestErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func ObserveLogin(authMethod string, err error) {
+	vaultLogins.WithLabelValues(authMethod, result(err)).Inc()
+}
+
+func ObserveRenewal(err error) {
+	vaultRenewals.WithLabelValues(result(err)).Inc()
+}
+
+// ListenAndServe serves the metrics at /metrics on the given address
+func ListenAndServe(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry}))
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
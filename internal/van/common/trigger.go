@@ -0,0 +1,57 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReconcileDelay is the time a ReconcileTrigger waits for
+	// further changes before firing
+	DefaultReconcileDelay = time.Second
+)
+
+// ReconcileTrigger coalesces bursts of change notifications into a single
+// reconcile request, sent through C once no further changes have been
+// notified for the given delay.
+type ReconcileTrigger struct {
+	C     chan struct{}
+	delay time.Duration
+	timer *time.Timer
+	mu    sync.Mutex
+}
+
+func NewReconcileTrigger(delay time.Duration) *ReconcileTrigger {
+	return &ReconcileTrigger{
+		C:     make(chan struct{}, 1),
+		delay: delay,
+	}
+}
+
+// Trigger notifies a change, (re)starting the delay
+func (t *ReconcileTrigger) Trigger() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer == nil {
+		t.timer = time.AfterFunc(t.delay, t.fire)
+		return
+	}
+	t.timer.Reset(t.delay)
+}
+
+// Stop discards pending notifications
+func (t *ReconcileTrigger) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+func (t *ReconcileTrigger) fire() {
+	// a pending request already covers this change
+	select {
+	case t.C <- struct{}{}:
+	default:
+	}
+}
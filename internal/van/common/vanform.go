@@ -2,48 +2,131 @@ package common
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/fgiorgetti/vanform/internal/client"
+	"github.com/fgiorgetti/vanform/internal/metrics"
 	"github.com/fgiorgetti/vanform/internal/van"
+	"github.com/skupperproject/skupper/pkg/apis/skupper/v2alpha1"
+	corev1 "k8s.io/api/core/v1"
 )
 
+const (
+	maxPublishAttempts = 3
+)
+
+type TokenStoreFactory func(ctx context.Context, config *van.Config, secret *corev1.Secret, saveSecret van.SecretSaver) (van.TokenStore, error)
+
 type vanFormClient struct {
 	siteName  string
+	siteUID   string
 	namespace string
-	vault     *client.Vault
+	store     van.TokenStore
 	vanConfig *van.Config
+	tokenTTL  time.Duration
+	status    *van.Status
 	logger    *slog.Logger
 }
 
 type VanForm struct {
 	ConfigLoader van.ConfigLoader
 	TokenHandler van.PlatformTokenHandler
+	// NewTokenStore creates the token store based on the loaded
+	// configuration (defaults to client.NewTokenStore)
+	NewTokenStore TokenStoreFactory
+	// StatusWriter (optional) reports the status after each Process call
+	StatusWriter van.StatusWriter
+
+	// store is reused across Process calls until the configuration
+	// or the credentials used to create it change
+	store      van.TokenStore
+	sessionKey string
+	mu         sync.Mutex
 }
 
-func (v *VanForm) Process(siteName, namespace string) error {
+// Process publishes the tokens of the given site and consumes the
+// tokens published by other sites in the VAN.
+func (v *VanForm) Process(site *v2alpha1.Site, namespace string) error {
+	start := time.Now()
+	err := v.process(site, namespace)
+	metrics.ObserveReconcile(namespace, start, err)
+	return err
+}
+
+func (v *VanForm) process(site *v2alpha1.Site, namespace string) error {
+	logger := slog.Default().With(
+		slog.String("namespace", namespace),
+		slog.String("siteName", site.Name),
+	)
+	// the status is also reported when failing, as it may describe the cause
+	status := &van.Status{}
+	defer v.writeStatus(status, logger)
 	config, secret, err := v.ConfigLoader.LoadConfig()
 	if err != nil {
 		return fmt.Errorf("error loading config: %w", err)
 	}
-	vault, err := client.NewAppRoleClient(config, secret)
+	tokenTTL, err := config.GetTokenTTL()
 	if err != nil {
-		return fmt.Errorf("error creating app role client: %w", err)
+		return fmt.Errorf("error loading config: %w", err)
 	}
-	_, err = vault.Login(context.Background())
+	store, err := v.getTokenStore(config, secret)
 	if err != nil {
-		return fmt.Errorf("vault login has failed: %w", err)
+		return err
+	}
+	if err = store.PersistCredentials(); err != nil {
+		// retried on every Process call, as credentials may only exist in memory
+		logger.Error("unable to persist credentials", slog.Any("error", err))
+		status.CredentialsError = err.Error()
+	}
+	policy, err := store.GetPolicy()
+	if errors.Is(err, van.ErrPolicyDenied) {
+		// not failing, but an enforced policy cannot be applied
+		logger.Warn("VAN policy cannot be read, only the local configuration is applied", slog.Any("error", err))
+		status.PolicyError = err.Error()
+	} else if err != nil {
+		return fmt.Errorf("error loading VAN policy: %w", err)
+	}
+	if policy != nil {
+		applied := *config
+		applied.Zones, status.PolicyConflicts = policy.Apply(config.Zones)
+		for _, conflict := range status.PolicyConflicts {
+			logger.Warn("zone configuration overridden by the VAN policy",
+				slog.String("zone", conflict.Zone),
+				slog.String("reason", conflict.Reason),
+			)
+		}
+		config = &applied
+	}
+	if err = store.RegisterZones(site.Name, config.Zones.Names()); err != nil {
+		// the zones of this site will not be resolved by patterns until registered
+		logger.Warn("unable to register zones", slog.Any("error", err))
+	}
+	if config.Zones.HasPatterns() {
+		// patterns in reachable_from are resolved against the zones present in the VAN
+		zones, err := store.ListZones()
+		if err != nil {
+			return fmt.Errorf("error resolving zones: %w", err)
+		}
+		resolved := *config
+		resolved.Zones = config.Zones.Resolve(zones)
+		config = &resolved
 	}
-	logger := slog.Default().With(
-		slog.String("namespace", namespace),
-		slog.String("siteName", siteName),
-	)
 	vfClient := &vanFormClient{
-		siteName:  siteName,
+		siteName:  site.Name,
+		siteUID:   string(site.UID),
 		namespace: namespace,
-		vault:     vault,
+		store:     store,
 		vanConfig: config,
+		tokenTTL:  tokenTTL,
+		status:    status,
 		logger:    logger,
 	}
 	err = v.publishTokens(vfClient)
@@ -57,10 +140,118 @@ func (v *VanForm) Process(siteName, namespace string) error {
 	return nil
 }
 
+func (v *VanForm) writeStatus(status *van.Status, logger *slog.Logger) {
+	if v.StatusWriter == nil {
+		return
+	}
+	if err := v.StatusWriter.WriteStatus(status); err != nil {
+		logger.Error("error writing status", slog.Any("error", err))
+	}
+}
+
+// Close releases the token store session held by the VanForm.
+func (v *VanForm) Close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.store != nil {
+		v.store.Close()
+		v.store = nil
+		v.sessionKey = ""
+	}
+}
+
+// getTokenStore returns the current token store session, creating a new one
+// if there is none yet or if the connection settings or the credentials have changed.
+func (v *VanForm) getTokenStore(config *van.Config, secret *corev1.Secret) (van.TokenStore, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	sessionKey, err := getSessionKey(config, secret)
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating token store session: %w", err)
+	}
+	if v.store != nil && v.sessionKey == sessionKey {
+		storeConfig := *config
+		v.store.UpdateConfig(&storeConfig)
+		return v.store, nil
+	}
+	if v.store != nil {
+		slog.Default().Info("Connection settings or credentials changed, creating a new token store session",
+			slog.String("van", config.VAN))
+		v.store.Close()
+		v.store = nil
+	}
+	newTokenStore := v.NewTokenStore
+	if newTokenStore == nil {
+		newTokenStore = client.NewTokenStore
+	}
+	// the store may update the secret while logging in, in which case the
+	// session key must reflect the persisted secret to avoid a new session
+	saveSecret := func(updated *corev1.Secret) error {
+		if err := v.ConfigLoader.SaveSecret(updated); err != nil {
+			return err
+		}
+		secret = updated
+		return nil
+	}
+	// the store may set defaults into its own copy of the configuration
+	storeConfig := *config
+	store, err := newTokenStore(context.Background(), &storeConfig, secret, saveSecret)
+	if err != nil {
+		return nil, fmt.Errorf("error creating token store: %w", err)
+	}
+	sessionKey, err = getSessionKey(config, secret)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("error evaluating token store session: %w", err)
+	}
+	v.store = store
+	v.sessionKey = sessionKey
+	return store, nil
+}
+
+// getSessionKey returns a hash of the settings used to connect and log in to
+// the token store, so that changes to the zones do not require a new session.
+func getSessionKey(config *van.Config, secret *corev1.Secret) (string, error) {
+	var secretData map[string][]byte
+	if secret != nil {
+		secretData = secret.Data
+	}
+	data, err := json.Marshal(struct {
+		VAN            string
+		Backend        string
+		URL            string
+		VaultNamespace string
+		Path           string
+		KVVersion      int
+		AuthMethod     string
+		AuthRole       string
+		AuthPath       string
+		ServiceAccount string
+		Secret         map[string][]byte
+	}{
+		config.VAN,
+		config.Backend,
+		config.URL,
+		config.VaultNamespace,
+		config.Path,
+		config.KVVersion,
+		config.AuthMethod,
+		config.AuthRole,
+		config.AuthPath,
+		config.AuthServiceAccount,
+		secretData,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
 func (v *VanForm) publishTokens(client *vanFormClient) error {
-	getToken := func(tokens []*van.Token, targetZone string) *van.Token {
+	getToken := func(tokens []*van.Token, siteZone, targetZone string) *van.Token {
 		for _, token := range tokens {
-			if token.TargetZone == targetZone {
+			if token.SiteZone == siteZone && token.TargetZone == targetZone {
 				return token
 			}
 		}
@@ -76,12 +267,10 @@ func (v *VanForm) publishTokens(client *vanFormClient) error {
 		logger.Error("Error generating tokens", slog.Any("error", err))
 		return err
 	}
-	// TODO retrieve all existing tokens for provided siteName
-	// TODO delete old tokens created by this siteName
 	var publishedTokens []*van.Token
 	for _, zone := range client.vanConfig.Zones {
 		for _, targetZone := range zone.ReachableFrom {
-			token, err := client.vault.GetPublishedToken(client.siteName, zone.Name, targetZone)
+			token, err := client.store.GetPublishedToken(client.siteName, zone.Name, targetZone)
 			if err != nil {
 				logger.Error("Error retrieving published token",
 					slog.String("siteZone", zone.Name),
@@ -101,9 +290,22 @@ func (v *VanForm) publishTokens(client *vanFormClient) error {
 	}
 	var tokensToPublish []*van.Token
 	for _, token := range generatedTokens {
-		pubToken := getToken(publishedTokens, token.TargetZone)
-		if pubToken != nil && pubToken.Equals(token) {
+		token.SiteUID = client.siteUID
+		token.Publisher = van.InstanceId()
+		pubToken := getToken(publishedTokens, token.SiteZone, token.TargetZone)
+		if v.isOwnedByOthers(client, pubToken) {
+			continue
+		}
+		// tokens published without identity are published again to include it
+		if pubToken != nil && !pubToken.Deleted && pubToken.SiteUID == token.SiteUID && pubToken.Equals(token) {
 			logger.Debug("token already published", "targetZone", token.TargetZone)
+			err = client.store.RefreshPublishedToken(client.siteName, token.SiteZone, token.TargetZone)
+			if err != nil {
+				logger.Error("error refreshing published token",
+					slog.String("siteZone", token.SiteZone),
+					slog.String("targetZone", token.TargetZone),
+					slog.Any("error", err))
+			}
 			continue
 		}
 		tokensToPublish = append(tokensToPublish, token)
@@ -115,16 +317,119 @@ func (v *VanForm) publishTokens(client *vanFormClient) error {
 			slog.String("targetZone", token.TargetZone),
 		)
 		logger.Info("publishing token")
-		err = client.vault.PublishToken(*token)
+		err = v.publishToken(client, token, getToken(publishedTokens, token.SiteZone, token.TargetZone))
 		if err != nil {
 			logger.Error("error publishing token",
 				slog.Any("error", err))
 			return fmt.Errorf("error publishing token: %w", err)
 		}
 	}
+	v.deleteStaleTokens(client, generatedTokens)
 	return nil
 }
 
+// publishToken publishes the given token, expecting the currently published
+// token to be the provided one. If the published token has been modified in
+// the meantime, it is read again and the token is re-evaluated.
+func (v *VanForm) publishToken(client *vanFormClient, token, published *van.Token) error {
+	logger := client.logger.With(
+		slog.String("siteZone", token.SiteZone),
+		slog.String("targetZone", token.TargetZone),
+	)
+	for attempt := 1; ; attempt++ {
+		token.Version = 0
+		if published != nil {
+			token.Version = published.Version
+		}
+		err := client.store.PublishToken(*token)
+		if err == nil {
+			metrics.TokenPublished(token.SiteZone, token.TargetZone)
+		}
+		if !errors.Is(err, van.ErrTokenConflict) || attempt == maxPublishAttempts {
+			return err
+		}
+		logger.Warn("published token has been modified concurrently, re-evaluating", slog.Int("attempt", attempt))
+		published, err = client.store.GetPublishedToken(client.siteName, token.SiteZone, token.TargetZone)
+		if err != nil {
+			return err
+		}
+		if v.isOwnedByOthers(client, published) {
+			return nil
+		}
+		if published != nil && !published.Deleted && published.SiteUID == token.SiteUID && published.Equals(token) {
+			logger.Debug("token already published")
+			return nil
+		}
+	}
+}
+
+// isOwnedByOthers returns true if the published token belongs to a different
+// site (with the same name) and has not expired, reporting the conflict.
+func (v *VanForm) isOwnedByOthers(client *vanFormClient, published *van.Token) bool {
+	if published == nil || published.Deleted || published.OwnedBy(client.siteName, client.siteUID) || published.Expired(client.tokenTTL) {
+		return false
+	}
+	client.logger.Error("token is published by another site with the same name, it will not be overwritten",
+		slog.String("siteZone", published.SiteZone),
+		slog.String("targetZone", published.TargetZone),
+		slog.String("siteUID", client.siteUID),
+		slog.String("ownerUID", published.SiteUID),
+		slog.String("publisher", published.Publisher),
+	)
+	client.status.Conflicts = append(client.status.Conflicts, van.TokenConflict{
+		SiteZone:   published.SiteZone,
+		TargetZone: published.TargetZone,
+		OwnerUID:   published.SiteUID,
+		Publisher:  published.Publisher,
+	})
+	return true
+}
+
+// deleteStaleTokens removes tokens published by this site that are no longer
+// produced by the current configuration (zone removed, no longer reachable
+// from a given target zone or no endpoints exposed to it). Failures are
+// logged and retried on the next cycle.
+func (v *VanForm) deleteStaleTokens(client *vanFormClient, generatedTokens []*van.Token) {
+	isGenerated := func(token *van.Token) bool {
+		for _, generated := range generatedTokens {
+			if generated.SiteZone == token.SiteZone && generated.TargetZone == token.TargetZone {
+				return true
+			}
+		}
+		return false
+	}
+	logger := client.logger
+	publishedTokens, err := client.store.ListPublishedTokens(client.siteName)
+	if err != nil {
+		logger.Warn("unable to list published tokens, stale tokens will not be removed", slog.Any("error", err))
+		return
+	}
+	for _, token := range publishedTokens {
+		if !token.OwnedBy(client.siteName, client.siteUID) {
+			continue
+		}
+		if isGenerated(token) {
+			continue
+		}
+		logger.Info("deleting stale published token",
+			slog.String("siteZone", token.SiteZone),
+			slog.String("targetZone", token.TargetZone),
+		)
+		err = client.store.DeletePublishedToken(client.siteName, token.SiteZone, token.TargetZone)
+		if err != nil {
+			logger.Error("error deleting stale published token",
+				slog.String("siteZone", token.SiteZone),
+				slog.String("targetZone", token.TargetZone),
+				slog.Any("error", err))
+			continue
+		}
+		metrics.TokenDeleted(token.SiteZone, token.TargetZone)
+	}
+	if err = client.store.PruneZones(client.siteName, client.vanConfig.Zones.Names()); err != nil {
+		logger.Warn("unable to unregister zones", slog.Any("error", err))
+	}
+}
+
 func (v *VanForm) consumeTokens(client *vanFormClient) error {
 	byName := func(tokens []*van.Token, linkName string) *van.Token {
 		for _, token := range tokens {
@@ -140,14 +445,34 @@ func (v *VanForm) consumeTokens(client *vanFormClient) error {
 		logger.Error("error loading existing links", slog.Any("error", err))
 		return fmt.Errorf("error loading existing links: %v", err)
 	}
-	availableTokens, err := client.vault.GetAvailableTokens(client.siteName)
+	storeTokens, failedZones, err := client.store.GetAvailableTokens()
 	if err != nil {
 		logger.Error("error getting available tokens", slog.Any("error", err))
 		return fmt.Errorf("error getting available tokens: %v", err)
 	}
-	var createList, deleteList []*van.Token
+	var availableTokens []*van.Token
+	for _, token := range storeTokens {
+		if token.OwnedBy(client.siteName, client.siteUID) {
+			logger.Debug("ignoring self-token", slog.String("linkName", token.Link.Name))
+			continue
+		}
+		// applying local overrides before comparing, so links are not seen as changed
+		token.RewriteHosts(client.vanConfig.HostAliases)
+		if cost, ok := client.vanConfig.ZoneCost(token.TargetZone, token.SiteZone); ok {
+			token.SetCost(cost)
+		}
+		availableTokens = append(availableTokens, token)
+	}
+	var createList, updateList, deleteList []*van.Token
 	for _, existingToken := range existingTokens {
 		availableToken := byName(availableTokens, existingToken.Link.Name)
+		if availableToken == nil && slices.Contains(failedZones, existingToken.TargetZone) {
+			logger.Warn("Link will be kept as its zone could not be read",
+				slog.String("linkName", existingToken.Link.Name),
+				slog.String("targetZone", existingToken.TargetZone),
+			)
+			continue
+		}
 		if availableToken == nil {
 			logger.Info("Link will be removed", slog.String("linkName", existingToken.Link.Name))
 			deleteList = append(deleteList, existingToken)
@@ -164,8 +489,7 @@ func (v *VanForm) consumeTokens(client *vanFormClient) error {
 		}
 		if !existingToken.Equals(availableToken) {
 			logger.Info("Link will be updated", slog.String("linkName", existingToken.Link.Name))
-			deleteList = append(deleteList, existingToken)
-			createList = append(createList, availableToken)
+			updateList = append(updateList, availableToken)
 			continue
 		}
 		logger.Debug("Link has no changes", slog.String("linkName", existingToken.Link.Name))
@@ -178,23 +502,43 @@ func (v *VanForm) consumeTokens(client *vanFormClient) error {
 			continue
 		}
 	}
-	for _, tokenDelete := range deleteList {
-		err = v.TokenHandler.Delete(tokenDelete)
+	// new links are created and existing ones are updated before
+	// removing the old ones, so that connectivity is not lost
+	links := len(existingTokens)
+	for _, tokenCreate := range createList {
+		err = v.TokenHandler.Save(tokenCreate)
 		if err != nil {
-			logger.Error("error deleting link",
-				slog.String("linkName", tokenDelete.Link.Name),
+			logger.Error("error creating link",
+				slog.String("linkName", tokenCreate.Link.Name),
 				slog.Any("error", err),
 			)
+			continue
 		}
+		links++
+		metrics.TokenConsumed(tokenCreate.SiteZone, tokenCreate.TargetZone)
 	}
-	for _, tokenCreate := range createList {
-		err = v.TokenHandler.Save(tokenCreate)
+	for _, tokenUpdate := range updateList {
+		err = v.TokenHandler.Update(tokenUpdate)
 		if err != nil {
-			logger.Error("error creating link",
-				slog.String("linkName", tokenCreate.Link.Name),
+			logger.Error("error updating link",
+				slog.String("linkName", tokenUpdate.Link.Name),
 				slog.Any("error", err),
 			)
+			continue
+		}
+		metrics.TokenConsumed(tokenUpdate.SiteZone, tokenUpdate.TargetZone)
+	}
+	for _, tokenDelete := range deleteList {
+		err = v.TokenHandler.Delete(tokenDelete)
+		if err != nil {
+			logger.Error("error deleting link",
+				slog.String("linkName", tokenDelete.Link.Name),
+				slog.Any("error", err),
+			)
+			continue
 		}
+		links--
 	}
+	metrics.SetAutoVanLinks(client.namespace, links)
 	return nil
 }
@@ -0,0 +1,643 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/fgiorgetti/vanform/internal/van"
+	"github.com/skupperproject/skupper/pkg/apis/skupper/v2alpha1"
+	"gotest.tools/v3/assert"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type fakeStore struct {
+	published map[string]van.Token
+	refreshed map[string]int
+	closed    bool
+	// failedZones simulates zones that could not be read
+	failedZones []string
+	// zones holds the sites registered in each zone
+	zones  map[string][]string
+	policy *van.Policy
+	// policyErr is returned by GetPolicy
+	policyErr error
+	config    *van.Config
+	// credentialsErr is returned by PersistCredentials
+	credentialsErr error
+	// beforePublish is called once on the next PublishToken call
+	beforePublish func()
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{published: map[string]van.Token{}, refreshed: map[string]int{}, zones: map[string][]string{}}
+}
+
+func (s *fakeStore) key(siteName, sourceZone, targetZone string) string {
+	return fmt.Sprintf("%s/%s-%s", targetZone, sourceZone, siteName)
+}
+
+func (s *fakeStore) GetAvailableTokens() ([]*van.Token, []string, error) {
+	var tokens []*van.Token
+	for _, token := range s.published {
+		if token.Deleted || slices.Contains(s.failedZones, token.TargetZone) {
+			continue
+		}
+		tokens = append(tokens, &token)
+	}
+	return tokens, s.failedZones, nil
+}
+
+func (s *fakeStore) PublishToken(token van.Token) error {
+	if s.beforePublish != nil {
+		s.beforePublish()
+		s.beforePublish = nil
+	}
+	key := s.key(token.SiteName, token.SiteZone, token.TargetZone)
+	if s.published[key].Version != token.Version {
+		return fmt.Errorf("version mismatch: %w", van.ErrTokenConflict)
+	}
+	token.Version++
+	s.published[key] = token
+	return nil
+}
+
+func (s *fakeStore) GetPublishedToken(siteName, sourceZone, targetZone string) (*van.Token, error) {
+	token, ok := s.published[s.key(siteName, sourceZone, targetZone)]
+	if !ok {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+func (s *fakeStore) RefreshPublishedToken(siteName, sourceZone, targetZone string) error {
+	s.refreshed[s.key(siteName, sourceZone, targetZone)]++
+	return nil
+}
+
+func (s *fakeStore) ListPublishedTokens(siteName string) ([]*van.Token, error) {
+	var tokens []*van.Token
+	for _, token := range s.published {
+		if token.SiteName == siteName && !token.Deleted {
+			tokens = append(tokens, &token)
+		}
+	}
+	return tokens, nil
+}
+
+func (s *fakeStore) DeletePublishedToken(siteName, sourceZone, targetZone string) error {
+	delete(s.published, s.key(siteName, sourceZone, targetZone))
+	return nil
+}
+
+// softDelete simulates a KV v2 soft delete, which keeps the version of the token
+func (s *fakeStore) softDelete(siteName, sourceZone, targetZone string) {
+	key := s.key(siteName, sourceZone, targetZone)
+	s.published[key] = van.Token{
+		SiteName:   siteName,
+		SiteZone:   sourceZone,
+		TargetZone: targetZone,
+		Version:    s.published[key].Version,
+		Deleted:    true,
+	}
+}
+
+func (s *fakeStore) RegisterZones(siteName string, zones []string) error {
+	for _, zone := range zones {
+		if !slices.Contains(s.zones[zone], siteName) {
+			s.zones[zone] = append(s.zones[zone], siteName)
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) PruneZones(siteName string, zones []string) error {
+	for zone, sites := range s.zones {
+		if slices.Contains(zones, zone) {
+			continue
+		}
+		s.zones[zone] = slices.DeleteFunc(sites, func(name string) bool { return name == siteName })
+	}
+	return nil
+}
+
+func (s *fakeStore) ListZones() ([]string, error) {
+	var zones []string
+	for zone, sites := range s.zones {
+		if len(sites) > 0 {
+			zones = append(zones, zone)
+		}
+	}
+	return zones, nil
+}
+
+func (s *fakeStore) GetPolicy() (*van.Policy, error) {
+	return s.policy, s.policyErr
+}
+
+func (s *fakeStore) UpdateConfig(config *van.Config) {
+	s.config = config
+}
+
+func (s *fakeStore) PersistCredentials() error {
+	return s.credentialsErr
+}
+
+func (s *fakeStore) Close() {
+	s.closed = true
+}
+
+type fakeTokenHandler struct {
+	generated []*van.Token
+	existing  map[string]*van.Token
+	updated   int
+	deleted   int
+}
+
+func newFakeTokenHandler(generated ...*van.Token) *fakeTokenHandler {
+	return &fakeTokenHandler{generated: generated, existing: map[string]*van.Token{}}
+}
+
+func (h *fakeTokenHandler) Load() ([]*van.Token, error) {
+	var tokens []*van.Token
+	for _, token := range h.existing {
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func (h *fakeTokenHandler) Save(token *van.Token) error {
+	h.existing[token.Link.Name] = token
+	return nil
+}
+
+func (h *fakeTokenHandler) Update(token *van.Token) error {
+	h.updated++
+	h.existing[token.Link.Name] = token
+	return nil
+}
+
+func (h *fakeTokenHandler) Generate(config *van.Config) ([]*van.Token, error) {
+	return h.generated, nil
+}
+
+func (h *fakeTokenHandler) Delete(token *van.Token) error {
+	h.deleted++
+	delete(h.existing, token.Link.Name)
+	return nil
+}
+
+func newToken(siteName, siteZone, targetZone, host string) *van.Token {
+	name := fmt.Sprintf("%s-%s", siteZone, siteName)
+	return &van.Token{
+		SiteName:   siteName,
+		SiteZone:   siteZone,
+		TargetZone: targetZone,
+		Link: &v2alpha1.Link{
+			ObjectMeta: v1.ObjectMeta{Name: name},
+			Spec: v2alpha1.LinkSpec{
+				Endpoints:      []v2alpha1.Endpoint{{Name: "inter-router", Host: host, Port: "55671"}},
+				TlsCredentials: name,
+				Cost:           1,
+			},
+		},
+		Secret: &corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: name},
+			Data:       map[string][]byte{"ca.crt": []byte(name)},
+		},
+	}
+}
+
+func newSite(name string) *v2alpha1.Site {
+	return &v2alpha1.Site{
+		ObjectMeta: v1.ObjectMeta{Name: name, UID: types.UID(name + "-uid")},
+	}
+}
+
+func newVanForm(config *van.Config, store van.TokenStore, handler van.PlatformTokenHandler) *VanForm {
+	return &VanForm{
+		ConfigLoader: &fakeConfigLoader{config: config},
+		TokenHandler: handler,
+		NewTokenStore: func(ctx context.Context, config *van.Config, secret *corev1.Secret, saveSecret van.SecretSaver) (van.TokenStore, error) {
+			return store, nil
+		},
+	}
+}
+
+type fakeConfigLoader struct {
+	config *van.Config
+	secret *corev1.Secret
+}
+
+func (l *fakeConfigLoader) LoadConfig() (*van.Config, *corev1.Secret, error) {
+	if l.secret == nil {
+		return l.config, &corev1.Secret{}, nil
+	}
+	return l.config, l.secret, nil
+}
+
+func (l *fakeConfigLoader) SaveSecret(secret *corev1.Secret) error {
+	l.secret = secret
+	return nil
+}
+
+func TestVanFormProcess(t *testing.T) {
+	store := newFakeStore()
+	westConfig := &van.Config{
+		VAN:   "test",
+		Zones: van.ZoneList{{Name: "west", ReachableFrom: []string{"east"}}},
+	}
+	eastConfig := &van.Config{
+		VAN:   "test",
+		Zones: van.ZoneList{{Name: "east"}},
+	}
+	westHandler := newFakeTokenHandler(newToken("west", "west", "east", "west.host"))
+	eastHandler := newFakeTokenHandler()
+	west := newVanForm(westConfig, store, westHandler)
+	east := newVanForm(eastConfig, store, eastHandler)
+
+	t.Run("publish", func(t *testing.T) {
+		assert.Assert(t, west.Process(newSite("west"), "west"))
+		assert.Equal(t, len(store.published), 1)
+		token, err := store.GetPublishedToken("west", "west", "east")
+		assert.Assert(t, err)
+		assert.Assert(t, token != nil)
+		assert.Equal(t, len(westHandler.existing), 0)
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		assert.Assert(t, west.Process(newSite("west"), "west"))
+		assert.Equal(t, store.refreshed[store.key("west", "west", "east")], 1)
+	})
+
+	t.Run("consume", func(t *testing.T) {
+		assert.Assert(t, east.Process(newSite("east"), "east"))
+		assert.Equal(t, len(eastHandler.existing), 1)
+		link, ok := eastHandler.existing["west-west"]
+		assert.Assert(t, ok)
+		assert.Equal(t, link.Link.Spec.Endpoints[0].Host, "west.host")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		westHandler.generated = []*van.Token{newToken("west", "west", "east", "new.west.host")}
+		assert.Assert(t, west.Process(newSite("west"), "west"))
+		assert.Assert(t, east.Process(newSite("east"), "east"))
+		assert.Equal(t, len(eastHandler.existing), 1)
+		assert.Equal(t, eastHandler.existing["west-west"].Link.Spec.Endpoints[0].Host, "new.west.host")
+		assert.Equal(t, eastHandler.updated, 1)
+		assert.Equal(t, eastHandler.deleted, 0, "updated links must not be deleted")
+	})
+
+	t.Run("soft-delete", func(t *testing.T) {
+		store.softDelete("west", "west", "east")
+		assert.Assert(t, west.Process(newSite("west"), "west"))
+		token, err := store.GetPublishedToken("west", "west", "east")
+		assert.Assert(t, err)
+		assert.Assert(t, !token.Deleted, "soft deleted token must be published again")
+		assert.Equal(t, token.Version, 3)
+	})
+
+	t.Run("zone-failure", func(t *testing.T) {
+		store.failedZones = []string{"east"}
+		assert.Assert(t, east.Process(newSite("east"), "east"))
+		assert.Equal(t, len(eastHandler.existing), 1, "links from unreadable zones must be kept")
+		store.failedZones = nil
+	})
+
+	t.Run("not-generated", func(t *testing.T) {
+		// i.e. no endpoints exposed to a target zone that is still reachable
+		westHandler.generated = nil
+		assert.Assert(t, west.Process(newSite("west"), "west"))
+		assert.Equal(t, len(store.published), 0, "token no longer generated must be deleted")
+		westHandler.generated = []*van.Token{newToken("west", "west", "east", "new.west.host")}
+		assert.Assert(t, west.Process(newSite("west"), "west"))
+		assert.Equal(t, len(store.published), 1)
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		westConfig.Zones[0].ReachableFrom = nil
+		westHandler.generated = nil
+		assert.Assert(t, west.Process(newSite("west"), "west"))
+		assert.Equal(t, len(store.published), 0, "stale token must be deleted")
+		assert.Assert(t, east.Process(newSite("east"), "east"))
+		assert.Equal(t, len(eastHandler.existing), 0)
+	})
+}
+
+func TestVanFormConsumerOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		override func(config *van.Config)
+		verify   func(t *testing.T, link *v2alpha1.Link)
+	}{
+		{
+			name: "host-aliases",
+			override: func(config *van.Config) {
+				config.HostAliases = map[string]string{"west.host": "10.0.0.1"}
+			},
+			verify: func(t *testing.T, link *v2alpha1.Link) {
+				assert.Equal(t, link.Spec.Endpoints[0].Host, "10.0.0.1")
+			},
+		},
+		{
+			name: "zone-costs",
+			override: func(config *van.Config) {
+				config.ZoneCosts = map[string]map[string]int{"east": {"west": 20}}
+			},
+			verify: func(t *testing.T, link *v2alpha1.Link) {
+				assert.Equal(t, link.Spec.Cost, 20)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newFakeStore()
+			westConfig := &van.Config{
+				VAN:   "test",
+				Zones: van.ZoneList{{Name: "west", ReachableFrom: []string{"east"}}},
+			}
+			eastConfig := &van.Config{
+				VAN:   "test",
+				Zones: van.ZoneList{{Name: "east"}},
+			}
+			test.override(eastConfig)
+			eastHandler := newFakeTokenHandler()
+			west := newVanForm(westConfig, store, newFakeTokenHandler(newToken("west", "west", "east", "west.host")))
+			east := newVanForm(eastConfig, store, eastHandler)
+
+			assert.Assert(t, west.Process(newSite("west"), "west"))
+			assert.Assert(t, east.Process(newSite("east"), "east"))
+			test.verify(t, eastHandler.existing["west-west"].Link)
+			published, err := store.GetPublishedToken("west", "west", "east")
+			assert.Assert(t, err)
+			assert.DeepEqual(t, published.Link.Spec, newToken("west", "west", "east", "west.host").Link.Spec)
+
+			assert.Assert(t, east.Process(newSite("east"), "east"))
+			assert.Equal(t, eastHandler.updated, 0, "overridden link must not be updated")
+			assert.Equal(t, eastHandler.deleted, 0)
+		})
+	}
+}
+
+func TestVanFormZonePatterns(t *testing.T) {
+	store := newFakeStore()
+	for _, zone := range []string{"eu-east", "eu-dmz", "us-east"} {
+		assert.Assert(t, store.RegisterZones(zone, []string{zone}))
+	}
+	westConfig := &van.Config{
+		VAN:   "test",
+		Zones: van.ZoneList{{Name: "west", ReachableFrom: []string{"eu-*", "!*-dmz", "south"}}},
+	}
+	westHandler := &fakeTokenHandler{existing: map[string]*van.Token{}}
+	west := newVanForm(westConfig, store, westHandler)
+	westHandler.generated = []*van.Token{
+		newToken("west", "west", "eu-east", "west.host"),
+		newToken("west", "west", "eu-north", "west.host"),
+		newToken("west", "west", "south", "west.host"),
+	}
+	// eu-north only consumes tokens, it is known through its registration
+	euNorthConfig := &van.Config{VAN: "test", Zones: van.ZoneList{{Name: "eu-north"}}}
+	euNorth := newVanForm(euNorthConfig, store, newFakeTokenHandler())
+	assert.Assert(t, euNorth.Process(newSite("eu-north"), "eu-north"))
+
+	assert.Assert(t, west.Process(newSite("west"), "west"))
+	published, err := store.ListPublishedTokens("west")
+	assert.Assert(t, err)
+	assert.Equal(t, len(published), 3)
+	for _, zone := range []string{"eu-east", "eu-north", "south"} {
+		token, err := store.GetPublishedToken("west", "west", zone)
+		assert.Assert(t, err)
+		assert.Assert(t, token != nil, "token must be published to %s", zone)
+	}
+
+	// the token published to a zone matched by pattern must not be considered stale
+	assert.Assert(t, west.Process(newSite("west"), "west"))
+	published, err = store.ListPublishedTokens("west")
+	assert.Assert(t, err)
+	assert.Equal(t, len(published), 3)
+
+	// abandoned zones are no longer matched by patterns
+	euNorthConfig.Zones = van.ZoneList{{Name: "us-north"}}
+	assert.Assert(t, euNorth.Process(newSite("eu-north"), "eu-north"))
+	zones, err := store.ListZones()
+	assert.Assert(t, err)
+	assert.Assert(t, !slices.Contains(zones, "eu-north"), "site must be unregistered from the zones it left")
+	westHandler.generated = westHandler.generated[:1]
+	assert.Assert(t, west.Process(newSite("west"), "west"))
+	token, err := store.GetPublishedToken("west", "west", "eu-north")
+	assert.Assert(t, err)
+	assert.Assert(t, token == nil, "token to an abandoned zone must be deleted")
+}
+
+func TestVanFormPolicy(t *testing.T) {
+	store := newFakeStore()
+	config := &van.Config{
+		VAN: "test",
+		Zones: van.ZoneList{
+			{Name: "west", ReachableFrom: []string{"east", "dmz"}},
+			{Name: "lab", ReachableFrom: []string{"east"}},
+		},
+	}
+	handler := newFakeTokenHandler(
+		newToken("west", "west", "east", "west.host"),
+		newToken("west", "west", "dmz", "west.host"),
+		newToken("west", "lab", "east", "west.host"),
+	)
+	statusWriter := &fakeStatusWriter{}
+	west := newVanForm(config, store, handler)
+	west.StatusWriter = statusWriter
+	assert.Assert(t, west.Process(newSite("west"), "west"))
+	assert.Equal(t, len(store.published), 3)
+
+	t.Run("merge", func(t *testing.T) {
+		store.policy = &van.Policy{
+			Zones: van.ZoneList{{Name: "west", ReachableFrom: []string{"north"}}},
+		}
+		handler.generated = append(handler.generated, newToken("west", "west", "north", "west.host"))
+		assert.Assert(t, west.Process(newSite("west"), "west"))
+		assert.Equal(t, len(store.published), 4)
+		assert.Equal(t, len(statusWriter.status.PolicyConflicts), 0)
+	})
+
+	t.Run("enforce", func(t *testing.T) {
+		store.policy = &van.Policy{
+			Mode:  van.PolicyModeEnforce,
+			Zones: van.ZoneList{{Name: "west", ReachableFrom: []string{"east"}}},
+		}
+		handler.generated = handler.generated[:1]
+		assert.Assert(t, west.Process(newSite("west"), "west"))
+		token, err := store.GetPublishedToken("west", "west", "dmz")
+		assert.Assert(t, err)
+		assert.Assert(t, token == nil, "token to a zone not allowed by the policy must be deleted")
+		token, err = store.GetPublishedToken("west", "lab", "east")
+		assert.Assert(t, err)
+		assert.Assert(t, token == nil, "token from a zone not defined by the policy must be deleted")
+		token, err = store.GetPublishedToken("west", "west", "east")
+		assert.Assert(t, err)
+		assert.Assert(t, token != nil)
+		assert.Equal(t, len(statusWriter.status.PolicyConflicts), 2)
+		assert.Equal(t, config.Zones[0].ReachableFrom[1], "dmz", "local configuration must not be modified")
+	})
+
+	t.Run("enforce-patterns", func(t *testing.T) {
+		store.policy = &van.Policy{
+			Mode:  van.PolicyModeEnforce,
+			Zones: van.ZoneList{{Name: "west", ReachableFrom: []string{"*", "!dmz"}}},
+		}
+		assert.Assert(t, west.Process(newSite("west"), "west"))
+		// west (east allowed by "*", dmz excluded) and lab (not defined by the policy)
+		assert.Equal(t, len(statusWriter.status.PolicyConflicts), 2)
+		for _, conflict := range statusWriter.status.PolicyConflicts {
+			assert.Assert(t, conflict.Reason != `reachable_from "east" is not allowed by the VAN policy`)
+		}
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		store.policy = nil
+		store.policyErr = fmt.Errorf("%w: 403", van.ErrPolicyDenied)
+		assert.Assert(t, west.Process(newSite("west"), "west"))
+		assert.Assert(t, statusWriter.status.PolicyError != "", "unreadable policy must be reported")
+		store.policyErr = nil
+	})
+}
+
+func TestVanFormSession(t *testing.T) {
+	var stores []*fakeStore
+	loader := &fakeConfigLoader{
+		config: &van.Config{VAN: "test", Zones: van.ZoneList{{Name: "west"}}},
+		secret: &corev1.Secret{Data: map[string][]byte{"secret-id": []byte("one")}},
+	}
+	vanForm := &VanForm{
+		ConfigLoader: loader,
+		TokenHandler: newFakeTokenHandler(),
+		NewTokenStore: func(ctx context.Context, config *van.Config, secret *corev1.Secret, saveSecret van.SecretSaver) (van.TokenStore, error) {
+			store := newFakeStore()
+			stores = append(stores, store)
+			return store, nil
+		},
+	}
+
+	assert.Assert(t, vanForm.Process(newSite("west"), "west"))
+	assert.Assert(t, vanForm.Process(newSite("west"), "west"))
+	assert.Equal(t, len(stores), 1, "session must be reused")
+
+	loader.config = &van.Config{VAN: "test", Zones: van.ZoneList{{Name: "west", ReachableFrom: []string{"east"}}}}
+	assert.Assert(t, vanForm.Process(newSite("west"), "west"))
+	assert.Equal(t, len(stores), 1, "session must be reused after zones changed")
+	assert.DeepEqual(t, stores[0].config.Zones, loader.config.Zones)
+
+	loader.secret = &corev1.Secret{Data: map[string][]byte{"secret-id": []byte("two")}}
+	assert.Assert(t, vanForm.Process(newSite("west"), "west"))
+	assert.Equal(t, len(stores), 2, "new session expected after credentials changed")
+	assert.Assert(t, stores[0].closed)
+	assert.Assert(t, !stores[1].closed)
+
+	vanForm.Close()
+	assert.Assert(t, stores[1].closed)
+}
+
+func TestVanFormSessionSecretSaved(t *testing.T) {
+	var stores []*fakeStore
+	loader := &fakeConfigLoader{
+		config: &van.Config{VAN: "test", Zones: van.ZoneList{{Name: "west"}}},
+		secret: &corev1.Secret{Data: map[string][]byte{"wrapped-secret-id": []byte("wrapped")}},
+	}
+	vanForm := &VanForm{
+		ConfigLoader: loader,
+		TokenHandler: newFakeTokenHandler(),
+		NewTokenStore: func(ctx context.Context, config *van.Config, secret *corev1.Secret, saveSecret van.SecretSaver) (van.TokenStore, error) {
+			if _, ok := secret.Data["wrapped-secret-id"]; ok {
+				err := saveSecret(&corev1.Secret{Data: map[string][]byte{"secret-id": []byte("unwrapped")}})
+				assert.Assert(t, err)
+			}
+			store := newFakeStore()
+			stores = append(stores, store)
+			return store, nil
+		},
+	}
+
+	assert.Assert(t, vanForm.Process(newSite("west"), "west"))
+	assert.DeepEqual(t, loader.secret.Data, map[string][]byte{"secret-id": []byte("unwrapped")})
+	assert.Assert(t, vanForm.Process(newSite("west"), "west"))
+	assert.Equal(t, len(stores), 1, "session must be reused after the secret has been saved")
+
+	status := &fakeStatusWriter{}
+	vanForm.StatusWriter = status
+	stores[0].credentialsErr = fmt.Errorf("unable to persist unwrapped secret-id")
+	assert.Assert(t, vanForm.Process(newSite("west"), "west"))
+	assert.Equal(t, status.status.CredentialsError, "unable to persist unwrapped secret-id")
+	stores[0].credentialsErr = nil
+	assert.Assert(t, vanForm.Process(newSite("west"), "west"))
+	assert.Assert(t, status.status.IsEmpty())
+}
+
+func TestVanFormPublishConflict(t *testing.T) {
+	store := newFakeStore()
+	config := &van.Config{
+		VAN:   "test",
+		Zones: van.ZoneList{{Name: "west", ReachableFrom: []string{"east"}}},
+	}
+	handler := newFakeTokenHandler(newToken("west", "west", "east", "west.host"))
+	west := newVanForm(config, store, handler)
+	assert.Assert(t, west.Process(newSite("west"), "west"))
+	key := store.key("west", "west", "east")
+	assert.Equal(t, store.published[key].Version, 1)
+
+	handler.generated = []*van.Token{newToken("west", "west", "east", "new.west.host")}
+	store.beforePublish = func() {
+		concurrent := *newToken("west", "west", "east", "concurrent.west.host")
+		concurrent.Version = 2
+		store.published[key] = concurrent
+	}
+	assert.Assert(t, west.Process(newSite("west"), "west"))
+	assert.Equal(t, store.published[key].Version, 3)
+	assert.Equal(t, store.published[key].Link.Spec.Endpoints[0].Host, "new.west.host")
+}
+
+func TestVanFormDuplicateSiteName(t *testing.T) {
+	store := newFakeStore()
+	config := &van.Config{
+		VAN:   "test",
+		Zones: van.ZoneList{{Name: "west", ReachableFrom: []string{"east"}}},
+	}
+	status := &fakeStatusWriter{}
+	west := newVanForm(config, store, newFakeTokenHandler(newToken("west", "west", "east", "west.host")))
+	otherWest := newVanForm(config, store, newFakeTokenHandler(newToken("west", "west", "east", "other.west.host")))
+	otherWest.StatusWriter = status
+	otherSite := newSite("west")
+	otherSite.UID = "other-west-uid"
+
+	assert.Assert(t, west.Process(newSite("west"), "west"))
+	assert.Assert(t, otherWest.Process(otherSite, "west"))
+	key := store.key("west", "west", "east")
+	assert.Equal(t, store.published[key].SiteUID, "west-uid")
+	assert.Equal(t, store.published[key].Link.Spec.Endpoints[0].Host, "west.host")
+	assert.Equal(t, len(status.status.Conflicts), 1)
+	assert.Equal(t, status.status.Conflicts[0].OwnerUID, "west-uid")
+}
+
+type fakeStatusWriter struct {
+	status *van.Status
+}
+
+func (w *fakeStatusWriter) WriteStatus(status *van.Status) error {
+	w.status = status
+	return nil
+}
+
+func TestVanFormStatusOnFailure(t *testing.T) {
+	statusWriter := &fakeStatusWriter{}
+	vanForm := &VanForm{
+		ConfigLoader: &fakeConfigLoader{config: &van.Config{VAN: "test", Zones: van.ZoneList{{Name: "west"}}}},
+		TokenHandler: newFakeTokenHandler(),
+		NewTokenStore: func(ctx context.Context, config *van.Config, secret *corev1.Secret, saveSecret van.SecretSaver) (van.TokenStore, error) {
+			return nil, fmt.Errorf("vault login has failed")
+		},
+		StatusWriter: statusWriter,
+	}
+	assert.Assert(t, vanForm.Process(newSite("west"), "west") != nil)
+	assert.Assert(t, statusWriter.status != nil, "status must be written when failing")
+}
@@ -0,0 +1,28 @@
+package van
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+var instanceId = newInstanceId()
+
+// InstanceId identifies the running VanForm controller
+func InstanceId() string {
+	return instanceId
+}
+
+func newInstanceId() string {
+	id := uuid.NewString()
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return id
+	}
+	// kept within the 63 characters allowed for label values
+	if len(hostname) > 54 {
+		hostname = hostname[:54]
+	}
+	return fmt.Sprintf("%s-%s", hostname, id[:8])
+}
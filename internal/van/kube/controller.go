@@ -6,15 +6,24 @@ import (
 	"sync"
 	"time"
 
+	"github.com/fgiorgetti/vanform/internal/metrics"
 	"github.com/fgiorgetti/vanform/internal/van"
+	"github.com/fgiorgetti/vanform/internal/van/common"
+	"github.com/skupperproject/skupper/pkg/apis/skupper/v2alpha1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/util/json"
 	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	defaultWorkers         = 4
+	defaultMaxRetryBackoff = 15 * time.Minute
 )
 
 type Controller struct {
@@ -24,6 +33,9 @@ type Controller struct {
 	logger         *slog.Logger
 	config         *van.ControllerConfig
 	mu             sync.Mutex
+	// queue holds the namespaces to be reconciled by the workers
+	queue   workqueue.TypedRateLimitingInterface[string]
+	workers sync.WaitGroup
 }
 
 func NewController(config *van.ControllerConfig) (*Controller, error) {
@@ -31,12 +43,27 @@ func NewController(config *van.ControllerConfig) (*Controller, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
 	}
+	if config.ResyncPeriod <= 0 {
+		config.ResyncPeriod = time.Minute
+	}
+	if config.MaxRetryBackoff <= 0 {
+		config.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+	if config.Workers <= 0 {
+		config.Workers = defaultWorkers
+	}
 	c := &Controller{
 		WatchNamespace: config.WatchNamespace,
 		client:         client,
 		instances:      make(map[string]*VanForm),
 		logger:         slog.Default(),
 		config:         config,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			// failures are retried with an exponential backoff, so that broken
+			// namespaces end up retried far less often than the healthy ones
+			workqueue.NewTypedItemExponentialFailureRateLimiter[string](time.Second, config.MaxRetryBackoff),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "vanform"},
+		),
 	}
 	return c, nil
 }
@@ -44,6 +71,10 @@ func NewController(config *van.ControllerConfig) (*Controller, error) {
 func (c *Controller) Start(stopCh chan struct{}) chan struct{} {
 	c.logger.Info("Starting controller", "platform", c.config.Platform, "watch-namespace", c.WatchNamespace)
 	doneCh := make(chan struct{})
+	if c.config.LeaderElect {
+		go c.runWithLeaderElection(stopCh, doneCh)
+		return doneCh
+	}
 	go c.run(stopCh, doneCh)
 	return doneCh
 }
@@ -59,10 +90,17 @@ func (c *Controller) run(stopCh chan struct{}, doneCh chan struct{}) {
 	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			u := obj.(*unstructured.Unstructured)
-			c.configmapAdded(u, stopCh)
+			c.configmapAdded(u)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldU := oldObj.(*unstructured.Unstructured)
+			newU := newObj.(*unstructured.Unstructured)
+			c.configmapUpdated(oldU, newU)
 		},
-		UpdateFunc: func(oldObj, newObj interface{}) {},
 		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
 			u := obj.(*unstructured.Unstructured)
 			c.configmapDeleted(u)
 		},
@@ -72,48 +110,148 @@ func (c *Controller) run(stopCh chan struct{}, doneCh chan struct{}) {
 		close(doneCh)
 		return
 	}
+	if err = c.watchResources(stopCh); err != nil {
+		c.logger.Error("Unable to watch resources", slog.Any("error", err))
+		close(doneCh)
+		return
+	}
+	for i := 0; i < c.config.Workers; i++ {
+		c.workers.Add(1)
+		go c.runWorker()
+	}
 	informer.Run(stopCh)
 	c.handleShutdown(stopCh, doneCh)
 }
 
+// watchResources triggers a reconciliation of the VanForm in the namespace
+// of the Sites, auto-van Links and auto-van Secrets that have changed
+func (c *Controller) watchResources(stopCh chan struct{}) error {
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.resourceChanged,
+		UpdateFunc: c.resourceUpdated,
+		DeleteFunc: c.resourceChanged,
+	}
+	siteInformerFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.client.Dynamic, 0, c.WatchNamespace, nil)
+	autoVanInformerFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.client.Dynamic, 0, c.WatchNamespace, func(options *v1.ListOptions) {
+		options.LabelSelector = "skupper.io/auto-van=true"
+	})
+	informers := []cache.SharedIndexInformer{
+		siteInformerFactory.ForResource(v2alpha1.SchemeGroupVersion.WithResource("sites")).Informer(),
+		autoVanInformerFactory.ForResource(v2alpha1.SchemeGroupVersion.WithResource("links")).Informer(),
+		autoVanInformerFactory.ForResource(schema.GroupVersionResource{Version: "v1", Resource: "secrets"}).Informer(),
+	}
+	for _, informer := range informers {
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			return err
+		}
+	}
+	siteInformerFactory.Start(stopCh)
+	autoVanInformerFactory.Start(stopCh)
+	return nil
+}
+
+func (c *Controller) resourceUpdated(oldObj, newObj interface{}) {
+	oldMeta, err := meta.Accessor(oldObj)
+	if err != nil {
+		return
+	}
+	newMeta, err := meta.Accessor(newObj)
+	if err != nil {
+		return
+	}
+	// ignoring periodic resyncs
+	if oldMeta.GetResourceVersion() == newMeta.GetResourceVersion() {
+		return
+	}
+	c.reconcile(newMeta.GetNamespace())
+}
+
+func (c *Controller) resourceChanged(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	objMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	c.reconcile(objMeta.GetNamespace())
+}
+
+// reconcile requests a reconciliation of the VanForm in the namespace,
+// once resource changes have settled
+func (c *Controller) reconcile(namespace string) {
+	c.mu.Lock()
+	_, exists := c.instances[namespace]
+	c.mu.Unlock()
+	if exists {
+		c.queue.AddAfter(namespace, common.DefaultReconcileDelay)
+	}
+}
+
+func (c *Controller) getVanForm(namespace string) *VanForm {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.instances[namespace]
+}
+
+func (c *Controller) runWorker() {
+	defer c.workers.Done()
+	for c.processNextItem() {
+	}
+}
+
+// processNextItem reconciles the next namespace in the queue. Failed namespaces
+// are retried with an exponential backoff, while the successful ones are
+// reconciled again after the resync period.
+func (c *Controller) processNextItem() bool {
+	namespace, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(namespace)
+	vanForm := c.getVanForm(namespace)
+	if vanForm == nil {
+		c.queue.Forget(namespace)
+		return true
+	}
+	if err := vanForm.Reconcile(); err != nil {
+		c.logger.Error("error reconciling VanForm",
+			slog.String("namespace", namespace),
+			slog.Int("retries", c.queue.NumRequeues(namespace)),
+			slog.Any("error", err))
+		c.queue.AddRateLimited(namespace)
+		return true
+	}
+	c.queue.Forget(namespace)
+	c.queue.AddAfter(namespace, c.config.ResyncPeriod)
+	return true
+}
+
 func (c *Controller) handleShutdown(stopCh chan struct{}, doneCh chan struct{}) {
 	<-stopCh
+	c.queue.ShutDown()
+	c.workers.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	for _, vanForm := range c.instances {
-		vanForm.Stop()
-	}
-	for {
-		var runningNamespaces []string
-		for namespace, vanForm := range c.instances {
-			if vanForm.IsRunning() {
-				runningNamespaces = append(runningNamespaces, namespace)
-			}
-		}
-		if len(runningNamespaces) == 0 {
-			c.logger.Info("all VanForm instances stopped")
-			close(doneCh)
-			return
-		}
-		c.logger.Info("VanForm instances still running", slog.Any("namespaces", runningNamespaces))
-		time.Sleep(time.Second)
+		vanForm.Close()
 	}
+	c.logger.Info("all VanForm instances stopped")
+	close(doneCh)
 }
 
-func (c *Controller) configmapAdded(u *unstructured.Unstructured, stopCh chan struct{}) {
+func (c *Controller) configmapAdded(u *unstructured.Unstructured) {
 	cm := new(corev1.ConfigMap)
 	err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, cm)
 	if err != nil {
 		c.logger.Error("failed to convert configmap", slog.Any("error", err))
 		return
 	}
-	configJson, ok := cm.Data["config.json"]
-	if !ok {
-		c.logger.Warn("failed to find config.json in skupper-van-form configmap", slog.Any("namespace", cm.Namespace))
-		return
-	}
-	var config van.Config
-	err = json.Unmarshal([]byte(configJson), &config)
-	if err != nil {
-		c.logger.Warn("failed to parse config.json", slog.Any("error", err))
+	if _, err = parseConfig(cm); err != nil {
+		c.logger.Warn("invalid skupper-van-form configmap", slog.String("namespace", cm.Namespace), slog.Any("error", err))
+		if err = reportConfigError(c.client, cm, err); err != nil {
+			c.logger.Error("unable to report configuration error", slog.String("namespace", cm.Namespace), slog.Any("error", err))
+		}
 		return
 	}
 	namespace := cm.Namespace
@@ -128,11 +266,44 @@ func (c *Controller) configmapAdded(u *unstructured.Unstructured, stopCh chan st
 				slog.Any("error", err))
 			return
 		}
-		v := NewVanForm(vc)
 		c.logger.Info("launching VanForm", slog.Any("namespace", namespace))
-		c.instances[namespace] = v
-		v.Start(stopCh)
+		// when watching all namespaces, vault logins must not rely on the
+		// service account of the controller, shared by all of them
+		vanForm := NewVanForm(vc, c.WatchNamespace == "")
+		// so that a status reported before (i.e. a config error) is cleared
+		vanForm.lastStatus = cm.Annotations[statusAnnotation]
+		c.instances[namespace] = vanForm
+		c.queue.Add(namespace)
+	}
+}
+
+// configmapUpdated validates the updated configuration and requests an
+// immediate reconciliation. VanForm instances keep running with their last
+// valid configuration (and report the error) when the new one is invalid.
+func (c *Controller) configmapUpdated(oldU, newU *unstructured.Unstructured) {
+	if oldU.GetResourceVersion() == newU.GetResourceVersion() {
+		return
+	}
+	cm := new(corev1.ConfigMap)
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(newU.Object, cm)
+	if err != nil {
+		c.logger.Error("failed to convert configmap", slog.Any("error", err))
+		return
+	}
+	c.mu.Lock()
+	_, exists := c.instances[cm.Namespace]
+	c.mu.Unlock()
+	if !exists {
+		// a VanForm is launched once the configuration becomes valid
+		c.configmapAdded(newU)
+		return
+	}
+	if _, err = parseConfig(cm); err != nil {
+		c.logger.Error("invalid skupper-van-form configmap, the last valid configuration will be used",
+			slog.String("namespace", cm.Namespace),
+			slog.Any("error", err))
 	}
+	c.queue.Add(cm.Namespace)
 }
 
 func (c *Controller) configmapDeleted(u *unstructured.Unstructured) {
@@ -150,6 +321,11 @@ func (c *Controller) configmapDeleted(u *unstructured.Unstructured) {
 		return
 	}
 	c.logger.Info("stopping VanForm", slog.Any("namespace", namespace))
-	vanForm.Stop()
 	delete(c.instances, namespace)
+	// Close waits for an ongoing reconciliation to complete, which
+	// would otherwise report metrics for the namespace once again
+	go func() {
+		vanForm.Close()
+		metrics.DeleteNamespace(namespace)
+	}()
 }
@@ -0,0 +1,108 @@
+package kube
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fgiorgetti/vanform/internal/van"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leaseName             = "skupper-vanform"
+	serviceAccountNsFile  = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	defaultLeaseNamespace = "default"
+)
+
+// runWithLeaderElection runs the controller only while holding the Lease.
+// The Lease is released once the controller has stopped, and the process
+// exits if the leadership is lost.
+func (c *Controller) runWithLeaderElection(stopCh chan struct{}, doneCh chan struct{}) {
+	defer close(doneCh)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var leading, stopping atomic.Bool
+	go func() {
+		<-stopCh
+		// a leader cancels it after the controller has stopped
+		if !leading.Load() {
+			stopping.Store(true)
+			cancel()
+		}
+	}()
+
+	namespace := c.leaseNamespace()
+	identity := van.InstanceId()
+	logger := c.logger.With(slog.String("lease", leaseName), slog.String("namespace", namespace))
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: v1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: namespace,
+		},
+		Client: c.client.GetKubeClient().CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            leaseName,
+		LeaseDuration:   c.config.LeaderElectLeaseDuration,
+		RenewDeadline:   c.config.LeaderElectRenewDeadline,
+		RetryPeriod:     c.config.LeaderElectRetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				leading.Store(true)
+				logger.Info("Leadership acquired", slog.String("identity", identity))
+				c.run(stopCh, make(chan struct{}))
+				stopping.Store(true)
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				if !leading.Load() {
+					return
+				}
+				if stopping.Load() {
+					logger.Info("Leadership released", slog.String("identity", identity))
+					return
+				}
+				logger.Error("Leadership lost, exiting", slog.String("identity", identity))
+				os.Exit(1)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					logger.Info("New leader elected", slog.String("leader", leader))
+				}
+			},
+		},
+	})
+	if err != nil {
+		logger.Error("Unable to start leader election", slog.Any("error", err))
+		return
+	}
+	logger.Info("Waiting for leadership", slog.String("identity", identity))
+	elector.Run(ctx)
+}
+
+// leaseNamespace returns the namespace of the Lease: the one defined
+// through the flags, the namespace of the controller or the watched one
+func (c *Controller) leaseNamespace() string {
+	for _, namespace := range []string{c.config.LeaderElectNamespace, c.config.Namespace, c.WatchNamespace} {
+		if namespace != "" {
+			return namespace
+		}
+	}
+	if data, err := os.ReadFile(serviceAccountNsFile); err == nil {
+		if namespace := strings.TrimSpace(string(data)); namespace != "" {
+			return namespace
+		}
+	}
+	return defaultLeaseNamespace
+}
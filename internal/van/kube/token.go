@@ -51,6 +51,8 @@ func (t *TokenHandler) Load() ([]*van.Token, error) {
 			SiteName:   l.ObjectMeta.Labels["skupper.io/site-name"],
 			SiteZone:   l.ObjectMeta.Labels["skupper.io/site-zone"],
 			TargetZone: l.ObjectMeta.Labels["skupper.io/target-zone"],
+			SiteUID:    l.ObjectMeta.Labels["skupper.io/site-id"],
+			Publisher:  l.ObjectMeta.Labels["skupper.io/van-form-instance"],
 			Link:       &l,
 			Secret:     secret,
 		})
@@ -75,6 +77,84 @@ func (t *TokenHandler) Save(token *van.Token) error {
 	return nil
 }
 
+func (t *TokenHandler) Update(token *van.Token) error {
+	linksCli := t.client.GetSkupperClient().SkupperV2alpha1().Links(t.client.Namespace)
+	token.Prepare()
+	token.Secret.Namespace = t.client.Namespace
+	token.Link.Namespace = t.client.Namespace
+	logger := t.logger.With(slog.String("link", token.Link.Name), slog.String("secret", token.Secret.Name))
+
+	// secret first, so the link never references missing credentials
+	if err := t.updateSecret(token.Secret); err != nil {
+		return err
+	}
+	var previousSecret string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		link, err := linksCli.Get(context.Background(), token.Link.Name, v1.GetOptions{})
+		if errors.IsNotFound(err) {
+			_, err = linksCli.Create(context.Background(), token.Link, v1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		previousSecret = link.Spec.TlsCredentials
+		if previousSecret == "" {
+			previousSecret = link.Name
+		}
+		link.Labels = token.Link.Labels
+		link.Spec = token.Link.Spec
+		_, err = linksCli.Update(context.Background(), link, v1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update link: %w", err)
+	}
+
+	if previousSecret == "" || previousSecret == token.Secret.Name {
+		return nil
+	}
+	existingTokens, err := t.Load()
+	if err != nil {
+		return err
+	}
+	for _, existingToken := range existingTokens {
+		if existingToken.Secret.Name == previousSecret {
+			logger.Debug("Previous secret is still in use", slog.String("previousSecret", previousSecret))
+			return nil
+		}
+	}
+	secretsCli := t.client.GetKubeClient().CoreV1().Secrets(t.client.Namespace)
+	err = secretsCli.Delete(context.Background(), previousSecret, v1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete previous secret: %w", err)
+	}
+	return nil
+}
+
+func (t *TokenHandler) updateSecret(secret *corev1.Secret) error {
+	secretsCli := t.client.GetKubeClient().CoreV1().Secrets(t.client.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := secretsCli.Get(context.Background(), secret.Name, v1.GetOptions{})
+		if errors.IsNotFound(err) {
+			_, err = secretsCli.Create(context.Background(), secret, v1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		existing.Labels = secret.Labels
+		existing.Type = secret.Type
+		existing.Data = secret.Data
+		_, err = secretsCli.Update(context.Background(), existing, v1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update secret: %w", err)
+	}
+	return nil
+}
+
 func (t *TokenHandler) Generate(config *van.Config) ([]*van.Token, error) {
 	if !config.Zones.Reachable() {
 		return nil, nil
@@ -100,12 +180,22 @@ func (t *TokenHandler) Generate(config *van.Config) ([]*van.Token, error) {
 		}
 
 		// Create a Link per endpoint group
-		var groupEndpoints = map[string][]v2alpha1.Endpoint{}
-		for _, endpoint := range site.Status.Endpoints {
-			groupEndpoints[endpoint.Group] = append(groupEndpoints[endpoint.Group], endpoint)
-		}
 		for _, targetZone := range zone.ReachableFrom {
-			for group, endpoints := range groupEndpoints {
+			groupEndpoints := t.getExposedEndpoints(zone, targetZone, site.Status.Endpoints)
+			if len(groupEndpoints) == 0 {
+				t.logger.Warn("No endpoints exposed to target zone",
+					slog.String("zone", zone.Name),
+					slog.String("targetZone", targetZone),
+					slog.Any("endpointGroups", zone.GetEndpointGroups(targetZone)),
+					slog.String("endpointHost", zone.GetEndpointHost(targetZone)),
+				)
+				continue
+			}
+			for group, hostEndpoints := range groupEndpoints {
+				cost := config.LinkCost(targetZone, zone.Name)
+				if cost == 0 {
+					cost = 1
+				}
 				linkName := fmt.Sprintf("%s-%s-%s", zone.Name, site.Name, group)
 				link := &v2alpha1.Link{
 					ObjectMeta: v1.ObjectMeta{
@@ -121,9 +211,9 @@ func (t *TokenHandler) Generate(config *van.Config) ([]*van.Token, error) {
 						APIVersion: v2alpha1.SchemeGroupVersion.String(),
 					},
 					Spec: v2alpha1.LinkSpec{
-						Endpoints:      endpoints,
+						Endpoints:      hostEndpoints,
 						TlsCredentials: cert.Name,
-						Cost:           1,
+						Cost:           cost,
 					},
 				}
 				tokens = append(tokens, &van.Token{
@@ -139,6 +229,27 @@ func (t *TokenHandler) Generate(config *van.Config) ([]*van.Token, error) {
 	return tokens, nil
 }
 
+// getExposedEndpoints returns the site endpoints exposed by the zone to the
+// target zone, by endpoint group. Groups with no exposed hosts are omitted.
+func (t *TokenHandler) getExposedEndpoints(zone van.Zone, targetZone string, endpoints []v2alpha1.Endpoint) map[string][]v2alpha1.Endpoint {
+	groupEndpoints := map[string][]v2alpha1.Endpoint{}
+	for _, endpoint := range endpoints {
+		if !zone.ExposesEndpointGroup(targetZone, endpoint.Group) {
+			t.logger.Debug("Endpoint group not exposed to target zone",
+				slog.String("zone", zone.Name),
+				slog.String("targetZone", targetZone),
+				slog.String("group", endpoint.Group),
+			)
+			continue
+		}
+		if !zone.ExposesEndpointHost(targetZone, endpoint.Host) {
+			continue
+		}
+		groupEndpoints[endpoint.Group] = append(groupEndpoints[endpoint.Group], endpoint)
+	}
+	return groupEndpoints
+}
+
 func (t *TokenHandler) Delete(token *van.Token) error {
 	existingTokens, err := t.Load()
 	if err != nil {
@@ -0,0 +1,68 @@
+package kube
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/fgiorgetti/vanform/internal/van"
+	"github.com/skupperproject/skupper/pkg/apis/skupper/v2alpha1"
+	"gotest.tools/v3/assert"
+)
+
+func TestGetExposedEndpoints(t *testing.T) {
+	endpoints := []v2alpha1.Endpoint{
+		{Name: "inter-router", Host: "west.example.com", Port: "55671", Group: "public"},
+		{Name: "inter-router", Host: "10.0.0.1", Port: "55671", Group: "private"},
+	}
+	handler := &TokenHandler{logger: slog.Default()}
+	tests := []struct {
+		name     string
+		zone     van.Zone
+		expected map[string][]string
+	}{
+		{
+			name:     "all",
+			zone:     van.Zone{Name: "west"},
+			expected: map[string][]string{"public": {"west.example.com"}, "private": {"10.0.0.1"}},
+		},
+		{
+			name: "target-overrides",
+			zone: van.Zone{
+				Name:                 "west",
+				EndpointGroups:       []string{"public"},
+				TargetEndpointGroups: map[string][]string{"east": {"private"}},
+			},
+			expected: map[string][]string{"private": {"10.0.0.1"}},
+		},
+		{
+			name:     "none-exposed",
+			zone:     van.Zone{Name: "west", EndpointGroups: []string{"internal"}},
+			expected: map[string][]string{},
+		},
+		{
+			name:     "host-pattern",
+			zone:     van.Zone{Name: "west", EndpointHost: "*.example.com"},
+			expected: map[string][]string{"public": {"west.example.com"}},
+		},
+		{
+			name: "no-host-exposed",
+			zone: van.Zone{
+				Name:           "west",
+				EndpointGroups: []string{"private"},
+				EndpointHost:   "*.example.com",
+			},
+			expected: map[string][]string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exposed := map[string][]string{}
+			for group, groupEndpoints := range handler.getExposedEndpoints(test.zone, "east", endpoints) {
+				for _, endpoint := range groupEndpoints {
+					exposed[group] = append(exposed[group], endpoint.Host)
+				}
+			}
+			assert.DeepEqual(t, exposed, test.expected)
+		})
+	}
+}
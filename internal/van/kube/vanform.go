@@ -7,32 +7,62 @@ import (
 	"sync"
 	"time"
 
+	vanclient "github.com/fgiorgetti/vanform/internal/client"
 	"github.com/fgiorgetti/vanform/internal/van"
 	"github.com/fgiorgetti/vanform/internal/van/common"
 	"github.com/skupperproject/skupper/pkg/apis/skupper/v2alpha1"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/json"
 )
 
-func NewVanForm(client *Client) *VanForm {
+// NewVanForm returns a VanForm for the namespace of the given client. When
+// requestTokens is set, the kubernetes auth method logs in using tokens of a
+// service account of the namespace, rather than the one of the controller.
+func NewVanForm(client *Client, requestTokens bool) *VanForm {
 	logger := slog.Default().With(
 		slog.String("namespace", client.Namespace),
 	)
-	return &VanForm{
+	f := &VanForm{
 		Namespace: client.Namespace,
 		logger:    logger,
 		client:    client,
-		stopCh:    make(chan struct{}),
 	}
+	f.vanForm = &common.VanForm{
+		ConfigLoader: f,
+		TokenHandler: NewTokenHandler(client),
+		StatusWriter: f,
+	}
+	if requestTokens {
+		f.vanForm.NewTokenStore = vanclient.NewTokenStoreWithJWT(f.requestServiceAccountToken)
+	}
+	return f
 }
 
+const (
+	statusAnnotation = "skupper.io/van-form-status"
+	// serviceAccountTokenExpiration is only relevant for logging in
+	serviceAccountTokenExpiration = 10 * time.Minute
+)
+
+// VanForm reconciles the tokens of a namespace. Reconcile is called by
+// the Controller workers, which never run it concurrently for the same
+// namespace.
 type VanForm struct {
-	Namespace string
-	stopCh    chan struct{}
-	logger    *slog.Logger
-	client    *Client
-	mu        sync.Mutex
+	Namespace  string
+	logger     *slog.Logger
+	client     *Client
+	vanForm    *common.VanForm
+	lastStatus string
+	// lastConfig is the last valid configuration loaded, used while
+	// the ConfigMap holds an invalid one (reported through configError)
+	lastConfig  *van.Config
+	configError string
+	closed      bool
+	mu          sync.Mutex
 }
 
 func (f *VanForm) LoadConfig() (*van.Config, *corev1.Secret, error) {
@@ -43,25 +73,33 @@ func (f *VanForm) LoadConfig() (*van.Config, *corev1.Secret, error) {
 		f.logger.Error(err.Error())
 		return nil, nil, err
 	}
-	configJson, ok := cm.Data["config.json"]
-	if !ok {
-		err = fmt.Errorf("unable to find config.json in skupper-van-form ConfigMap")
-		f.logger.Error(err.Error())
-		return nil, nil, err
-	}
-	var config van.Config
-	err = json.Unmarshal([]byte(configJson), &config)
+	parsedConfig, err := parseConfig(cm)
 	if err != nil {
-		err = fmt.Errorf("unable to parse config.json in skupper-van-form ConfigMap: %w", err)
 		f.logger.Error(err.Error())
-		return nil, nil, err
+		f.configError = err.Error()
+		if f.lastConfig == nil {
+			return nil, nil, err
+		}
+		// keep running with the last good configuration
+		f.logger.Warn("using last valid configuration")
+		parsedConfig = f.lastConfig
+	} else {
+		f.lastConfig = parsedConfig
+		f.configError = ""
 	}
+	config := *parsedConfig
 	vaultSecretName := config.Secret
 	if vaultSecretName == "" {
 		vaultSecretName = "skupper-van-form"
 	}
 	secretsCli := f.client.GetKubeClient().CoreV1().Secrets(f.Namespace)
 	secret, err := secretsCli.Get(context.Background(), vaultSecretName, v1.GetOptions{})
+	if err != nil && errors.IsNotFound(err) && config.AuthMethod == van.AuthMethodKubernetes {
+		// kubernetes auth can be fully defined through the configmap
+		f.logger.Debug("vault secret not found, using kubernetes auth settings from config.json",
+			slog.String("secret", vaultSecretName))
+		return &config, &corev1.Secret{ObjectMeta: v1.ObjectMeta{Name: vaultSecretName, Namespace: f.Namespace}}, nil
+	}
 	if err != nil {
 		err = fmt.Errorf("unable to get secret: %w", err)
 		f.logger.Error(err.Error())
@@ -70,54 +108,146 @@ func (f *VanForm) LoadConfig() (*van.Config, *corev1.Secret, error) {
 	return &config, secret, nil
 }
 
-func (f *VanForm) Stop() {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	close(f.stopCh)
-	f.stopCh = nil
+// parseConfig parses and validates the config.json of the skupper-van-form ConfigMap
+func parseConfig(cm *corev1.ConfigMap) (*van.Config, error) {
+	configJson, ok := cm.Data["config.json"]
+	if !ok {
+		return nil, fmt.Errorf("unable to find config.json in skupper-van-form ConfigMap")
+	}
+	config := new(van.Config)
+	err := json.Unmarshal([]byte(configJson), config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse config.json in skupper-van-form ConfigMap: %w", err)
+	}
+	if err = config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config.json in skupper-van-form ConfigMap: %w", err)
+	}
+	return config, nil
+}
+
+func (f *VanForm) SaveSecret(secret *corev1.Secret) error {
+	secretsCli := f.client.GetKubeClient().CoreV1().Secrets(f.Namespace)
+	_, err := secretsCli.Update(context.Background(), secret, v1.UpdateOptions{})
+	if err != nil {
+		err = fmt.Errorf("unable to update secret: %w", err)
+		f.logger.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// WriteStatus stores the status as an annotation of the
+// skupper-van-form ConfigMap, if it has changed.
+func (f *VanForm) WriteStatus(status *van.Status) error {
+	var statusValue *string
+	if f.configError != "" {
+		withError := *status
+		withError.ConfigError = f.configError
+		status = &withError
+	}
+	if !status.IsEmpty() {
+		statusJson, err := json.Marshal(status)
+		if err != nil {
+			return fmt.Errorf("unable to marshal status: %w", err)
+		}
+		statusStr := string(statusJson)
+		statusValue = &statusStr
+	}
+	lastStatus := ""
+	if statusValue != nil {
+		lastStatus = *statusValue
+	}
+	if lastStatus == f.lastStatus {
+		return nil
+	}
+	if err := patchStatus(f.client, f.Namespace, statusValue); err != nil {
+		return err
+	}
+	f.lastStatus = lastStatus
+	return nil
+}
+
+// reportConfigError reports an invalid configuration in the status of a
+// skupper-van-form ConfigMap with no VanForm running, if not yet reported
+func reportConfigError(client *Client, cm *corev1.ConfigMap, configErr error) error {
+	statusJson, err := json.Marshal(&van.Status{ConfigError: configErr.Error()})
+	if err != nil {
+		return fmt.Errorf("unable to marshal status: %w", err)
+	}
+	statusStr := string(statusJson)
+	if cm.Annotations[statusAnnotation] == statusStr {
+		return nil
+	}
+	return patchStatus(client, cm.Namespace, &statusStr)
+}
+
+// patchStatus sets the status annotation of the skupper-van-form ConfigMap,
+// a nil value removes the annotation
+func patchStatus(client *Client, namespace string, statusValue *string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{
+				statusAnnotation: statusValue,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to marshal status patch: %w", err)
+	}
+	cmCli := client.GetKubeClient().CoreV1().ConfigMaps(namespace)
+	_, err = cmCli.Patch(context.Background(), "skupper-van-form", types.MergePatchType, patch, v1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to update status: %w", err)
+	}
+	return nil
 }
 
-func (f *VanForm) Start(parentCh chan struct{}) {
-	go f.run(parentCh)
+// Reconcile publishes and consumes the tokens of the ready site
+// in the namespace, if any
+func (f *VanForm) Reconcile() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil
+	}
+	site, err := f.getSite()
+	if err != nil {
+		return err
+	}
+	if site == nil {
+		f.logger.Debug("no ready site found")
+		return nil
+	}
+	if err = f.vanForm.Process(site, f.Namespace); err != nil {
+		return fmt.Errorf("error processing tokens: %w", err)
+	}
+	return nil
 }
 
-func (f *VanForm) IsRunning() bool {
+// Close releases the token store session of the VanForm
+func (f *VanForm) Close() {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	return f.stopCh != nil
+	f.closed = true
+	f.vanForm.Close()
 }
 
-func (f *VanForm) run(parentCh chan struct{}) {
-	var err error
-	var site *v2alpha1.Site
-	vanForm := &common.VanForm{
-		ConfigLoader: f,
-		TokenHandler: NewTokenHandler(f.client),
+// requestServiceAccountToken returns a short-lived token of the given
+// service account of the namespace, obtained through the TokenRequest API
+func (f *VanForm) requestServiceAccountToken(ctx context.Context, serviceAccount string) (string, error) {
+	expirationSeconds := int64(serviceAccountTokenExpiration.Seconds())
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expirationSeconds},
 	}
-	resync := time.NewTicker(time.Minute)
-	for {
-		site, err = f.getSite()
-		if err != nil {
-			f.logger.Error("unable to get ready site", "error", err.Error())
-		} else {
-			err = vanForm.Process(site.Name, f.Namespace)
-			if err != nil {
-				f.logger.Error("error processing tokens", slog.Any("error", err))
-			}
-		}
-		select {
-		case <-resync.C:
-			continue
-		case <-parentCh:
-			f.logger.Info("VanForm has stopped - parent requested")
-			return
-		case <-f.stopCh:
-			f.logger.Info("VanForm has stopped")
-			return
-		}
+	saCli := f.client.GetKubeClient().CoreV1().ServiceAccounts(f.Namespace)
+	tokenRequest, err := saCli.CreateToken(ctx, serviceAccount, tokenRequest, v1.CreateOptions{})
+	if err != nil {
+		return "", err
 	}
+	return tokenRequest.Status.Token, nil
 }
 
+// getSite returns the first ready site in the namespace (nil if none is ready)
 func (f *VanForm) getSite() (*v2alpha1.Site, error) {
 	siteCli := f.client.GetSkupperClient().SkupperV2alpha1().Sites(f.Namespace)
 	sites, err := siteCli.List(context.Background(), v1.ListOptions{})
@@ -132,11 +262,5 @@ func (f *VanForm) getSite() (*v2alpha1.Site, error) {
 			break
 		}
 	}
-	if site == nil {
-		noSiteFoundMsg := "no ready site found"
-		f.logger.Debug(noSiteFoundMsg)
-		return nil, fmt.Errorf("%s", noSiteFoundMsg)
-	}
-
 	return site, nil
 }
@@ -0,0 +1,96 @@
+package van
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	PolicyModeMerge   = "merge"
+	PolicyModeEnforce = "enforce"
+)
+
+// Policy is the VAN-wide definition of zones and of which zones may reach
+// which, stored centrally in the token store.
+type Policy struct {
+	// Mode defines how the policy is applied to the zones of a site:
+	// merge (default) adds the reachability defined by the policy to the
+	// local one, enforce replaces the local reachability by the policy's
+	Mode  string   `json:"mode,omitempty"`
+	Zones ZoneList `json:"zones"`
+}
+
+// PolicyConflict describes a local zone setting overridden by the VAN policy
+type PolicyConflict struct {
+	Zone   string `json:"zone"`
+	Reason string `json:"reason"`
+}
+
+func (p *Policy) Validate() error {
+	switch p.Mode {
+	case "", PolicyModeMerge, PolicyModeEnforce:
+	default:
+		return fmt.Errorf("invalid policy mode: %q", p.Mode)
+	}
+	for _, zone := range p.Zones {
+		if zone.Name == "" {
+			return fmt.Errorf("policy zones must have a name")
+		}
+	}
+	return nil
+}
+
+// Apply returns a copy of the given zones with the policy applied, along
+// with the local settings that have been overridden by the policy.
+func (p *Policy) Apply(zones ZoneList) (ZoneList, []PolicyConflict) {
+	var conflicts []PolicyConflict
+	applied := make(ZoneList, 0, len(zones))
+	for _, zone := range zones {
+		policyZone := p.getZone(zone.Name)
+		switch {
+		case p.Mode != PolicyModeEnforce:
+			if policyZone != nil {
+				zone.ReachableFrom = slices.Clone(zone.ReachableFrom)
+				for _, targetZone := range policyZone.ReachableFrom {
+					if !slices.Contains(zone.ReachableFrom, targetZone) {
+						zone.ReachableFrom = append(zone.ReachableFrom, targetZone)
+					}
+				}
+			}
+		case policyZone == nil:
+			if zone.Reachable() {
+				conflicts = append(conflicts, PolicyConflict{
+					Zone:   zone.Name,
+					Reason: "zone is not defined by the VAN policy, it will not be reachable",
+				})
+			}
+			zone.ReachableFrom = nil
+		default:
+			for _, targetZone := range zone.ReachableFrom {
+				// local exclusions only narrow the reachability
+				if strings.HasPrefix(targetZone, "!") {
+					continue
+				}
+				if !policyZone.IsReachableFromZone(targetZone) {
+					conflicts = append(conflicts, PolicyConflict{
+						Zone:   zone.Name,
+						Reason: fmt.Sprintf("reachable_from %q is not allowed by the VAN policy", targetZone),
+					})
+				}
+			}
+			zone.ReachableFrom = slices.Clone(policyZone.ReachableFrom)
+		}
+		applied = append(applied, zone)
+	}
+	return applied, conflicts
+}
+
+func (p *Policy) getZone(name string) *Zone {
+	for i := range p.Zones {
+		if p.Zones[i].Name == name {
+			return &p.Zones[i]
+		}
+	}
+	return nil
+}
@@ -8,8 +8,10 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/fgiorgetti/vanform/internal/filesystem"
+	"github.com/fgiorgetti/vanform/internal/van"
 	"github.com/skupperproject/skupper/pkg/nonkube/api"
 )
 
@@ -17,15 +19,17 @@ const (
 	lockFileName = "vanform.lock"
 )
 
-func NewController() *Controller {
+func NewController(config *van.ControllerConfig) *Controller {
 	return &Controller{
 		namespaces: map[string]chan struct{}{},
 		logger:     slog.Default(),
+		config:     config,
 	}
 }
 
 type Controller struct {
 	namespaces map[string]chan struct{}
+	config     *van.ControllerConfig
 	watcher    *filesystem.FileWatcher
 	logger     *slog.Logger
 	mu         sync.Mutex
@@ -93,7 +97,8 @@ func (c *Controller) OnCreate(path string) {
 	defer c.mu.Unlock()
 	ns := c.namespace(path)
 	cmHandler := &ConfigMapHandler{
-		Namespace: ns,
+		Namespace:    ns,
+		ResyncPeriod: c.config.ResyncPeriod,
 	}
 	stopCh := make(chan struct{})
 	c.namespaces[ns] = stopCh
@@ -125,6 +130,7 @@ func (c *Controller) Filter(path string) bool {
 
 type ConfigMapHandler struct {
 	Namespace     string
+	ResyncPeriod  time.Duration
 	vanFormStopCh chan struct{}
 	mu            sync.Mutex
 }
@@ -159,7 +165,7 @@ func (c *ConfigMapHandler) OnCreate(path string) {
 		return
 	}
 	c.vanFormStopCh = make(chan struct{})
-	vanForm := NewVanForm(c.Namespace)
+	vanForm := NewVanForm(c.Namespace, c.ResyncPeriod)
 	err := vanForm.Start(c.vanFormStopCh)
 	if err != nil {
 		logger.Error("unable to start VanForm", "error", err)
@@ -58,6 +58,8 @@ func (t *TokenHandler) Load() ([]*van.Token, error) {
 			SiteName:   link.ObjectMeta.Labels["skupper.io/site-name"],
 			SiteZone:   link.ObjectMeta.Labels["skupper.io/site-zone"],
 			TargetZone: link.ObjectMeta.Labels["skupper.io/target-zone"],
+			SiteUID:    link.ObjectMeta.Labels["skupper.io/site-id"],
+			Publisher:  link.ObjectMeta.Labels["skupper.io/van-form-instance"],
 			Link:       &link,
 			Secret:     secretsMap[secretName],
 		})
@@ -69,17 +71,60 @@ func (t *TokenHandler) Save(token *van.Token) error {
 	token.Prepare()
 	token.Link.ObjectMeta.Namespace = t.Namespace
 	token.Secret.ObjectMeta.Namespace = t.Namespace
-	err := WriteResource(t.Namespace, "Link", token.Link.Name, token.Link)
+	err := WriteResource(t.Namespace, "Link", token.Link.Name, token.Link, false)
 	if err != nil {
 		return fmt.Errorf("failed to write link: %w", err)
 	}
-	err = WriteResource(t.Namespace, "Secret", token.Secret.Name, token.Secret)
+	err = WriteResource(t.Namespace, "Secret", token.Secret.Name, token.Secret, false)
 	if err != nil {
 		return fmt.Errorf("failed to write secret: %w", err)
 	}
 	return nil
 }
 
+func (t *TokenHandler) Update(token *van.Token) error {
+	links, err := LoadResources[v2alpha1.Link](t.Namespace, "Link", false)
+	if err != nil {
+		return err
+	}
+	var previousSecret string
+	for _, link := range links {
+		if link.Name == token.Link.Name {
+			previousSecret = link.Spec.TlsCredentials
+			if previousSecret == "" {
+				previousSecret = link.Name
+			}
+		}
+	}
+
+	token.Prepare()
+	token.Link.ObjectMeta.Namespace = t.Namespace
+	token.Secret.ObjectMeta.Namespace = t.Namespace
+	err = WriteResource(t.Namespace, "Secret", token.Secret.Name, token.Secret, false)
+	if err != nil {
+		return fmt.Errorf("failed to write secret: %w", err)
+	}
+	err = WriteResource(t.Namespace, "Link", token.Link.Name, token.Link, false)
+	if err != nil {
+		return fmt.Errorf("failed to write link: %w", err)
+	}
+
+	if previousSecret == "" || previousSecret == token.Secret.Name {
+		return nil
+	}
+	for _, link := range links {
+		if link.Name != token.Link.Name && (link.Spec.TlsCredentials == previousSecret || link.Name == previousSecret) {
+			return nil
+		}
+	}
+	inputPath := api.GetInternalOutputPath(t.Namespace, api.InputSiteStatePath)
+	err = os.Remove(path.Join(inputPath, fmt.Sprintf("Secret-%s.yaml", previousSecret)))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete previous secret: %w", err)
+	}
+	return nil
+}
+
 func (t *TokenHandler) Generate(config *van.Config) ([]*van.Token, error) {
 	var tokens []*van.Token
 	var err error
@@ -103,12 +148,15 @@ func (t *TokenHandler) Generate(config *van.Config) ([]*van.Token, error) {
 		}
 		linkName := fmt.Sprintf("%s-%s", zone.Name, site.Name)
 		for _, targetZone := range zone.ReachableFrom {
-			token, err := t.loadTokenForHost(zone.EndpointHost)
+			token, err := t.loadTokenForHost(zone, targetZone)
 			if err != nil {
 				return nil, err
 			}
 			token.Link.ObjectMeta.Name = linkName
 			token.Link.Spec.TlsCredentials = linkName
+			if cost := config.LinkCost(targetZone, zone.Name); cost > 0 {
+				token.Link.Spec.Cost = cost
+			}
 			token.Secret.ObjectMeta.Name = linkName
 			token.SiteZone = zone.Name
 			token.TargetZone = targetZone
@@ -132,7 +180,8 @@ func (t *TokenHandler) Delete(token *van.Token) error {
 	return nil
 }
 
-func (t *TokenHandler) loadTokenForHost(host string) (*van.Token, error) {
+func (t *TokenHandler) loadTokenForHost(zone van.Zone, targetZone string) (*van.Token, error) {
+	host := zone.GetEndpointHost(targetZone)
 	tokensPath := api.GetInternalOutputPath(t.Namespace, api.RuntimeTokenPath)
 	files, err := os.ReadDir(tokensPath)
 	if err != nil {
@@ -152,7 +201,7 @@ func (t *TokenHandler) loadTokenForHost(host string) (*van.Token, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to load token file %s: %w", tokenFileName, err)
 		}
-		if host == "" || token.Link.Spec.Endpoints[0].Host == host {
+		if host == "" || (len(token.Link.Spec.Endpoints) > 0 && zone.ExposesEndpointHost(targetZone, token.Link.Spec.Endpoints[0].Host)) {
 			return token, nil
 		}
 	}
@@ -195,16 +244,43 @@ func LoadResources[T any](namespace, kind string, runtime bool) ([]T, error) {
 	return resources, nil
 }
 
-func WriteResource[T any](namespace, kind, name string, resource T) error {
+func WriteResource[T any](namespace, kind, name string, resource T, runtime bool) error {
 	inputPath := api.GetInternalOutputPath(namespace, api.InputSiteStatePath)
+	if runtime {
+		inputPath = api.GetInternalOutputPath(namespace, api.RuntimeSiteStatePath)
+	}
 	fileName := path.Join(inputPath, fmt.Sprintf("%s-%s.yaml", kind, name))
 	resourceData, err := yaml.Marshal(resource)
 	if err != nil {
 		return fmt.Errorf("unable to marshal file %s as %s: %w", fileName, kind, err)
 	}
-	err = os.WriteFile(fileName, resourceData, 0644)
+	err = writeFileAtomic(fileName, resourceData, 0644)
 	if err != nil {
 		return fmt.Errorf("unable to write file %s as %s: %w", fileName, kind, err)
 	}
 	return nil
 }
+
+// writeFileAtomic writes data to a temporary file in the same directory
+// and renames it to fileName, so readers never see a partially written file
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(path.Dir(fileName), "."+path.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), perm)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), fileName)
+}
@@ -3,20 +3,29 @@ package system
 import (
 	"fmt"
 	"log/slog"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/fgiorgetti/vanform/internal/filesystem"
 	"github.com/fgiorgetti/vanform/internal/van"
 	"github.com/fgiorgetti/vanform/internal/van/common"
 	"github.com/skupperproject/skupper/pkg/apis/skupper/v2alpha1"
+	"github.com/skupperproject/skupper/pkg/nonkube/api"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/json"
 )
 
-func NewVanForm(namespace string) *VanForm {
+func NewVanForm(namespace string, resyncPeriod time.Duration) *VanForm {
+	if resyncPeriod <= 0 {
+		resyncPeriod = time.Minute
+	}
 	return &VanForm{
-		namespace: namespace,
-		logger:    slog.Default().With("namespace", namespace),
+		namespace:    namespace,
+		logger:       slog.Default().With("namespace", namespace),
+		trigger:      common.NewReconcileTrigger(common.DefaultReconcileDelay),
+		resyncPeriod: resyncPeriod,
 	}
 }
 
@@ -24,6 +33,9 @@ type VanForm struct {
 	namespace string
 	logger    *slog.Logger
 	mu        sync.Mutex
+	// trigger requests a reconciliation when watched files change
+	trigger      *common.ReconcileTrigger
+	resyncPeriod time.Duration
 }
 
 func (f *VanForm) LoadConfig() (*van.Config, *corev1.Secret, error) {
@@ -75,6 +87,19 @@ func (f *VanForm) LoadConfig() (*van.Config, *corev1.Secret, error) {
 	return &config, vaultSecret, nil
 }
 
+// SaveSecret writes the secret to both the input and the runtime paths, so
+// that the change is kept when the runtime resources are regenerated.
+func (f *VanForm) SaveSecret(secret *corev1.Secret) error {
+	for _, runtime := range []bool{false, true} {
+		if err := WriteResource(f.namespace, "Secret", secret.Name, secret, runtime); err != nil {
+			err = fmt.Errorf("unable to save secret: %w", err)
+			f.logger.Error(err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
 func (f *VanForm) Start(stopCh chan struct{}) error {
 	go f.run(stopCh)
 	return nil
@@ -82,25 +107,44 @@ func (f *VanForm) Start(stopCh chan struct{}) error {
 
 func (f *VanForm) run(stopCh chan struct{}) {
 	f.logger.Info("VanForm has started")
-	resync := time.NewTicker(time.Minute)
+	watcher, err := filesystem.NewWatcher(
+		slog.String("namespace", f.namespace),
+		slog.String("component", "VanForm"),
+	)
+	if err != nil {
+		f.logger.Error("unable to create file watcher, changes will only be reconciled periodically", "error", err)
+	} else {
+		for _, watchedPath := range []api.InternalPath{api.RuntimeSiteStatePath, api.RuntimeTokenPath, api.InputSiteStatePath} {
+			watcher.Add(api.GetInternalOutputPath(f.namespace, watchedPath), f)
+		}
+		watcher.Start(stopCh)
+	}
+	resync := time.NewTicker(f.resyncPeriod)
+	defer resync.Stop()
+	defer f.trigger.Stop()
 	tokenLoader := NewTokenHandler(f.namespace)
 	vanForm := &common.VanForm{
 		ConfigLoader: f,
 		TokenHandler: tokenLoader,
 	}
+	defer vanForm.Close()
 	for {
 		site, err := f.getSite()
 		if err != nil {
 			f.logger.Error("error loading site", "error", err.Error())
 		} else {
-			err = vanForm.Process(site.Name, f.namespace)
+			err = vanForm.Process(site, f.namespace)
 			if err != nil {
 				f.logger.Error("error processing tokens", "error", err.Error())
 			}
 		}
+		resync.Reset(f.resyncPeriod)
 		select {
 		case <-resync.C:
 			continue
+		case <-f.trigger.C:
+			f.logger.Debug("VanForm reconciling changes")
+			continue
 		case <-stopCh:
 			f.logger.Info("VanForm has stopped")
 			return
@@ -108,6 +152,28 @@ func (f *VanForm) run(stopCh chan struct{}) {
 	}
 }
 
+func (f *VanForm) OnBasePathAdded(basePath string) {
+}
+
+func (f *VanForm) OnCreate(path string) {
+	f.trigger.Trigger()
+}
+
+func (f *VanForm) OnUpdate(path string) {
+	f.trigger.Trigger()
+}
+
+func (f *VanForm) OnRemove(path string) {
+	f.trigger.Trigger()
+}
+
+// Filter ignores files other than resources, including
+// the temporary (hidden) files used to write them
+func (f *VanForm) Filter(name string) bool {
+	baseName := path.Base(name)
+	return strings.HasSuffix(baseName, ".yaml") && !strings.HasPrefix(baseName, ".")
+}
+
 func (f *VanForm) getSite() (*v2alpha1.Site, error) {
 	sites, err := LoadResources[*v2alpha1.Site](f.namespace, "Site", true)
 	if err != nil {
@@ -3,9 +3,14 @@ package van
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"path"
 	"reflect"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/skupperproject/skupper/pkg/apis/skupper/v2alpha1"
 	corev1 "k8s.io/api/core/v1"
@@ -27,26 +32,181 @@ type ControllerConfig struct {
 	Namespace      string
 	Platform       string
 	Kubeconfig     string
+	// ResyncPeriod is the interval of periodic reconciliations, performed
+	// besides the ones triggered by changes
+	ResyncPeriod time.Duration
+	// Workers is the number of namespaces reconciled concurrently
+	Workers int
+	// MaxRetryBackoff is the maximum delay between retries of failed
+	// reconciliations, expected to be well above the ResyncPeriod
+	MaxRetryBackoff time.Duration
+	// LeaderElect enables leader election, so that a single replica
+	// of the controller is active at a time
+	LeaderElect              bool
+	LeaderElectNamespace     string
+	LeaderElectLeaseDuration time.Duration
+	LeaderElectRenewDeadline time.Duration
+	LeaderElectRetryPeriod   time.Duration
+	// MetricsAddress is the address metrics are served at (disabled if empty)
+	MetricsAddress string
 }
 
+const (
+	BackendVault = "vault"
+
+	AuthMethodAppRole    = "approle"
+	AuthMethodKubernetes = "kubernetes"
+)
+
 type Config struct {
-	VAN    string   `json:"van"`
-	URL    string   `json:"url"`
-	Path   string   `json:"path"`
-	Secret string   `json:"secret"`
-	Zones  ZoneList `json:"zones"`
+	VAN            string   `json:"van"`
+	Backend        string   `json:"backend"`
+	URL            string   `json:"url"`
+	VaultNamespace string   `json:"vault_namespace"`
+	Path           string   `json:"path"`
+	KVVersion      int      `json:"kv_version"`
+	Secret         string   `json:"secret"`
+	AuthMethod     string   `json:"auth_method"`
+	AuthRole       string   `json:"auth_role"`
+	AuthPath       string   `json:"auth_path"`
+	Zones          ZoneList `json:"zones"`
+	// AuthServiceAccount is the service account of the watched namespace
+	// used by the kubernetes auth method when all namespaces are watched
+	AuthServiceAccount string `json:"auth_service_account,omitempty"`
+	// TokenTTL is the maximum age of the heartbeat of a published token
+	// for it to be consumed (i.e. 1h), tokens never expire if not set
+	TokenTTL          string `json:"token_ttl"`
+	ReapExpiredTokens bool   `json:"reap_expired_tokens"`
+	// HostAliases maps endpoint hosts advertised by other sites to the
+	// hosts used locally to reach them (i.e. behind NAT)
+	HostAliases map[string]string `json:"host_aliases,omitempty"`
+	// ZoneCosts is the cost matrix of links between zones, where
+	// ZoneCosts[from][to] is the cost of links established from sites
+	// in the "from" zone to sites in the "to" zone
+	ZoneCosts map[string]map[string]int `json:"zone_costs,omitempty"`
+}
+
+// ZoneCost returns the cost defined in the ZoneCosts matrix for links
+// established from sites in the fromZone to sites in the toZone
+func (c *Config) ZoneCost(fromZone, toZone string) (int, bool) {
+	cost, ok := c.ZoneCosts[fromZone][toZone]
+	return cost, ok
+}
+
+// LinkCost returns the cost of links established from sites in the
+// fromZone to sites in the toZone, falling back to the default cost of
+// the toZone. Zero is returned if no cost has been defined.
+func (c *Config) LinkCost(fromZone, toZone string) int {
+	if cost, ok := c.ZoneCost(fromZone, toZone); ok {
+		return cost
+	}
+	for _, zone := range c.Zones {
+		if zone.Name == toZone {
+			return zone.Cost
+		}
+	}
+	return 0
+}
+
+// GetTokenTTL returns the parsed TokenTTL (zero if not set)
+func (c *Config) GetTokenTTL() (time.Duration, error) {
+	if c.TokenTTL == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(c.TokenTTL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid token_ttl %q: %w", c.TokenTTL, err)
+	}
+	return ttl, nil
+}
+
+// Validate verifies the settings that can be checked without
+// reaching the token store
+func (c *Config) Validate() error {
+	if c.VAN == "" {
+		return fmt.Errorf("van is required")
+	}
+	switch c.Backend {
+	case "", BackendVault:
+	default:
+		return fmt.Errorf("unsupported token store backend: %q", c.Backend)
+	}
+	switch c.AuthMethod {
+	case "", AuthMethodAppRole, AuthMethodKubernetes:
+	default:
+		return fmt.Errorf("unsupported vault auth method: %q", c.AuthMethod)
+	}
+	switch c.KVVersion {
+	case 0, 1, 2:
+	default:
+		return fmt.Errorf("invalid kv_version: %d", c.KVVersion)
+	}
+	if _, err := c.GetTokenTTL(); err != nil {
+		return err
+	}
+	for _, zone := range c.Zones {
+		if zone.Name == "" {
+			return fmt.Errorf("zones must have a name")
+		}
+	}
+	return nil
 }
 
 type Zone struct {
 	Name          string   `json:"name"`
 	ReachableFrom []string `json:"reachable_from"`
 	EndpointHost  string   `json:"endpoint_host"`
+	// EndpointGroups limits the endpoint groups exposed to the zones this
+	// zone is reachable from (all groups are exposed when empty)
+	EndpointGroups []string `json:"endpoint_groups,omitempty"`
+	// TargetEndpointGroups overrides EndpointGroups for specific target zones
+	TargetEndpointGroups map[string][]string `json:"target_endpoint_groups,omitempty"`
+	// TargetEndpointHosts overrides EndpointHost for specific target zones
+	TargetEndpointHosts map[string]string `json:"target_endpoint_hosts,omitempty"`
+	// Cost is the default cost of links established to this zone
+	Cost int `json:"cost,omitempty"`
 }
 
 func (z Zone) Reachable() bool {
 	return len(z.ReachableFrom) > 0
 }
 
+// GetEndpointGroups returns the endpoint groups exposed to the given
+// target zone, or nil if all groups are exposed
+func (z Zone) GetEndpointGroups(targetZone string) []string {
+	if groups, ok := z.TargetEndpointGroups[targetZone]; ok {
+		return groups
+	}
+	return z.EndpointGroups
+}
+
+// GetEndpointHost returns the endpoint host (or host pattern) exposed
+// to the given target zone, or an empty string if any host can be used
+func (z Zone) GetEndpointHost(targetZone string) string {
+	if host, ok := z.TargetEndpointHosts[targetZone]; ok {
+		return host
+	}
+	return z.EndpointHost
+}
+
+// ExposesEndpointHost returns true if the given endpoint host matches the
+// endpoint host (or host pattern) exposed to the target zone
+func (z Zone) ExposesEndpointHost(targetZone, host string) bool {
+	pattern := z.GetEndpointHost(targetZone)
+	if pattern == "" || pattern == host {
+		return true
+	}
+	matched, _ := path.Match(pattern, host)
+	return matched
+}
+
+// ExposesEndpointGroup returns true if the given endpoint group can be
+// exposed to the target zone
+func (z Zone) ExposesEndpointGroup(targetZone, group string) bool {
+	groups := z.GetEndpointGroups(targetZone)
+	return len(groups) == 0 || slices.Contains(groups, group)
+}
+
 type ZoneList []Zone
 
 func (z ZoneList) Reachable() bool {
@@ -58,6 +218,75 @@ func (z ZoneList) Reachable() bool {
 	return false
 }
 
+// IsReachableFrom returns true if the given zone is reachable from the target zone
+func (z ZoneList) IsReachableFrom(name, targetZone string) bool {
+	for _, zone := range z {
+		if zone.Name == name && slices.Contains(zone.ReachableFrom, targetZone) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPatterns returns true if any zone uses wildcards, patterns or
+// exclusions in its ReachableFrom list
+func (z ZoneList) HasPatterns() bool {
+	for _, zone := range z {
+		for _, targetZone := range zone.ReachableFrom {
+			if isZonePattern(targetZone) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Resolve returns a copy of the ZoneList with wildcards, patterns (i.e. "eu-*")
+// and exclusions (i.e. "!dmz") in ReachableFrom resolved against the given
+// zones. Zones listed by name are kept even if not present.
+func (z ZoneList) Resolve(zones []string) ZoneList {
+	resolved := make(ZoneList, 0, len(z))
+	for _, zone := range z {
+		var reachableFrom []string
+		for _, targetZone := range zone.ReachableFrom {
+			if !isZonePattern(targetZone) && zone.IsReachableFromZone(targetZone) {
+				reachableFrom = append(reachableFrom, targetZone)
+			}
+		}
+		for _, name := range zones {
+			if zone.IsReachableFromZone(name) {
+				reachableFrom = append(reachableFrom, name)
+			}
+		}
+		slices.Sort(reachableFrom)
+		zone.ReachableFrom = slices.Compact(reachableFrom)
+		resolved = append(resolved, zone)
+	}
+	return resolved
+}
+
+// IsReachableFromZone returns true if the given zone is matched by name or
+// pattern in ReachableFrom, and not excluded (i.e. "!dmz")
+func (z Zone) IsReachableFromZone(name string) bool {
+	reachable := false
+	for _, targetZone := range z.ReachableFrom {
+		if exclusion, ok := strings.CutPrefix(targetZone, "!"); ok {
+			if matched, _ := path.Match(exclusion, name); matched || exclusion == name {
+				return false
+			}
+			continue
+		}
+		if matched, _ := path.Match(targetZone, name); matched || targetZone == name {
+			reachable = true
+		}
+	}
+	return reachable
+}
+
+func isZonePattern(name string) bool {
+	return strings.HasPrefix(name, "!") || strings.ContainsAny(name, "*?[")
+}
+
 func (z ZoneList) HasZone(name string) bool {
 	for _, zone := range z {
 		if zone.Name == name {
@@ -67,23 +296,138 @@ func (z ZoneList) HasZone(name string) bool {
 	return false
 }
 
+func (z ZoneList) Names() []string {
+	var names []string
+	for _, zone := range z {
+		names = append(names, zone.Name)
+	}
+	return names
+}
+
 type ConfigLoader interface {
 	LoadConfig() (*Config, *corev1.Secret, error)
+	// SaveSecret persists changes made to the credentials secret
+	SaveSecret(secret *corev1.Secret) error
+}
+
+// SecretSaver persists changes made to the credentials secret
+type SecretSaver func(secret *corev1.Secret) error
+
+// StatusWriter reports the status of a VanForm on the platform
+type StatusWriter interface {
+	WriteStatus(status *Status) error
+}
+
+type Status struct {
+	Conflicts       []TokenConflict  `json:"conflicts,omitempty"`
+	PolicyConflicts []PolicyConflict `json:"policy_conflicts,omitempty"`
+	// ConfigError reports an invalid configuration that has been ignored
+	ConfigError string `json:"config_error,omitempty"`
+	// CredentialsError reports credentials that could not be persisted
+	CredentialsError string `json:"credentials_error,omitempty"`
+	// PolicyError reports a VAN policy that could not be read
+	PolicyError string `json:"policy_error,omitempty"`
+}
+
+func (s *Status) IsEmpty() bool {
+	return len(s.Conflicts) == 0 && len(s.PolicyConflicts) == 0 && s.ConfigError == "" &&
+		s.CredentialsError == "" && s.PolicyError == ""
+}
+
+// TokenConflict describes a published token that is owned by another site
+type TokenConflict struct {
+	SiteZone   string `json:"site_zone"`
+	TargetZone string `json:"target_zone"`
+	OwnerUID   string `json:"owner_uid"`
+	Publisher  string `json:"publisher,omitempty"`
 }
 
 type PlatformTokenHandler interface {
 	Load() ([]*Token, error)
 	Save(token *Token) error
+	// Update replaces an existing link and its secret in place
+	Update(token *Token) error
 	Generate(van *Config) ([]*Token, error)
 	Delete(token *Token) error
 }
 
+// ErrPolicyDenied is returned by a TokenStore when the VAN policy
+// cannot be read due to missing permissions
+var ErrPolicyDenied = errors.New("permission denied reading the VAN policy")
+
+// ErrTokenConflict is returned by a TokenStore when a token could not be
+// published because it has been modified since it was last read
+var ErrTokenConflict = errors.New("published token has been modified concurrently")
+
+// TokenStore is the backend used to publish tokens generated by the
+// local site and to retrieve tokens published by other sites in the VAN.
+type TokenStore interface {
+	// GetAvailableTokens returns all tokens published to the configured zones
+	// and the zones whose tokens could not be retrieved
+	GetAvailableTokens() (tokens []*Token, failedZones []string, err error)
+	// PublishToken publishes the token only if the version of the published
+	// token still matches token.Version (0 if expected not to exist)
+	PublishToken(token Token) error
+	// GetPublishedToken returns the published token (nil if not found), or a
+	// Deleted one holding its current version if it has been soft deleted
+	GetPublishedToken(siteName, sourceZone, targetZone string) (*Token, error)
+	// RefreshPublishedToken updates the heartbeat of a published token
+	RefreshPublishedToken(siteName, sourceZone, targetZone string) error
+	ListPublishedTokens(siteName string) ([]*Token, error)
+	DeletePublishedToken(siteName, sourceZone, targetZone string) error
+	// RegisterZones records the given site as a member of the zones,
+	// so that they can be resolved by the zone patterns of other sites
+	RegisterZones(siteName string, zones []string) error
+	// PruneZones removes the given site from the zones it no longer
+	// belongs to (other than the given ones)
+	PruneZones(siteName string, zones []string) error
+	// ListZones returns the zones that have sites registered in them
+	ListZones() ([]string, error)
+	// GetPolicy returns the VAN policy, or nil if none is defined
+	// (ErrPolicyDenied if it cannot be read)
+	GetPolicy() (*Policy, error)
+	// UpdateConfig applies the settings that do not affect the session
+	// (i.e. zones and token TTL) to the store
+	UpdateConfig(config *Config)
+	// PersistCredentials retries persisting the credentials changed by the
+	// store while logging in, when a previous attempt has failed
+	PersistCredentials() error
+	// Close releases the resources held by the store session
+	Close()
+}
+
 type Token struct {
 	SiteName   string
 	SiteZone   string
 	TargetZone string
-	Link       *v2alpha1.Link
-	Secret     *corev1.Secret
+	// SiteUID is the UID of the Site that published the token
+	SiteUID string
+	// Publisher identifies the VanForm controller instance that published the token
+	Publisher string
+	Link      *v2alpha1.Link
+	Secret    *corev1.Secret
+	// Version of the token in the TokenStore (0 if not published)
+	Version int
+	// Heartbeat is the last time the publisher refreshed the token (zero if unknown)
+	Heartbeat time.Time
+	// Deleted is set on tokens soft deleted from the TokenStore, which
+	// are not published but still hold the Version to be replaced
+	Deleted bool
+}
+
+// OwnedBy returns true if the token has been published by the given site.
+// Tokens published without a site UID are matched by name.
+func (t *Token) OwnedBy(siteName, siteUID string) bool {
+	if t.SiteUID == "" || siteUID == "" {
+		return t.SiteName == siteName
+	}
+	return t.SiteUID == siteUID
+}
+
+// Expired returns true if the token heartbeat is older than the given ttl.
+// Tokens without a heartbeat never expire.
+func (t *Token) Expired(ttl time.Duration) bool {
+	return ttl > 0 && !t.Heartbeat.IsZero() && time.Since(t.Heartbeat) > ttl
 }
 
 func (t *Token) Prepare() {
@@ -95,6 +439,12 @@ func (t *Token) Prepare() {
 		meta.Labels["skupper.io/site-name"] = t.SiteName
 		meta.Labels["skupper.io/site-zone"] = t.SiteZone
 		meta.Labels["skupper.io/target-zone"] = t.TargetZone
+		if t.SiteUID != "" {
+			meta.Labels["skupper.io/site-id"] = t.SiteUID
+		}
+		if t.Publisher != "" {
+			meta.Labels["skupper.io/van-form-instance"] = t.Publisher
+		}
 	}
 	cleanUp := func(meta *v1.ObjectMeta) {
 		meta.ManagedFields = nil
@@ -163,6 +513,8 @@ func (t *Token) Unmarshal(data string) error {
 				t.SiteName = link.ObjectMeta.Labels["skupper.io/site-name"]
 				t.SiteZone = link.ObjectMeta.Labels["skupper.io/site-zone"]
 				t.TargetZone = link.ObjectMeta.Labels["skupper.io/target-zone"]
+				t.SiteUID = link.ObjectMeta.Labels["skupper.io/site-id"]
+				t.Publisher = link.ObjectMeta.Labels["skupper.io/van-form-instance"]
 			}
 		} else if corev1.SchemeGroupVersion == gvk.GroupVersion() {
 			switch gvk.Kind {
@@ -176,6 +528,29 @@ func (t *Token) Unmarshal(data string) error {
 	return nil
 }
 
+// RewriteHosts replaces the endpoint hosts of the Link that have an alias.
+// The Link is copied before being modified.
+func (t *Token) RewriteHosts(aliases map[string]string) {
+	if t.Link == nil || len(aliases) == 0 {
+		return
+	}
+	t.Link = t.Link.DeepCopy()
+	for i, endpoint := range t.Link.Spec.Endpoints {
+		if alias, ok := aliases[endpoint.Host]; ok {
+			t.Link.Spec.Endpoints[i].Host = alias
+		}
+	}
+}
+
+// SetCost sets the cost of the Link. The Link is copied before being modified.
+func (t *Token) SetCost(cost int) {
+	if t.Link == nil || t.Link.Spec.Cost == cost {
+		return
+	}
+	t.Link = t.Link.DeepCopy()
+	t.Link.Spec.Cost = cost
+}
+
 func (t *Token) Equals(other *Token) bool {
 	if other == nil {
 		return false
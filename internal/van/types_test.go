@@ -0,0 +1,58 @@
+package van
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestZoneEndpointGroups(t *testing.T) {
+	zone := Zone{
+		Name:                 "west",
+		EndpointGroups:       []string{"public"},
+		TargetEndpointGroups: map[string][]string{"east": {"private"}, "north": {}},
+	}
+	tests := []struct {
+		targetZone string
+		group      string
+		exposed    bool
+	}{
+		{targetZone: "south", group: "public", exposed: true},
+		{targetZone: "south", group: "private", exposed: false},
+		{targetZone: "east", group: "private", exposed: true},
+		{targetZone: "east", group: "public", exposed: false},
+		// an empty override exposes all groups
+		{targetZone: "north", group: "private", exposed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.targetZone+"/"+test.group, func(t *testing.T) {
+			assert.Equal(t, zone.ExposesEndpointGroup(test.targetZone, test.group), test.exposed)
+		})
+	}
+}
+
+func TestZoneEndpointHost(t *testing.T) {
+	zone := Zone{
+		Name:                "west",
+		EndpointHost:        "*.example.com",
+		TargetEndpointHosts: map[string]string{"east": "10.0.0.1", "north": "west-?.internal"},
+	}
+	tests := []struct {
+		targetZone string
+		host       string
+		exposed    bool
+	}{
+		{targetZone: "south", host: "west.example.com", exposed: true},
+		{targetZone: "south", host: "10.0.0.1", exposed: false},
+		{targetZone: "east", host: "10.0.0.1", exposed: true},
+		{targetZone: "east", host: "west.example.com", exposed: false},
+		{targetZone: "north", host: "west-1.internal", exposed: true},
+		{targetZone: "north", host: "west-10.internal", exposed: false},
+	}
+	for _, test := range tests {
+		t.Run(test.targetZone+"/"+test.host, func(t *testing.T) {
+			assert.Equal(t, zone.ExposesEndpointHost(test.targetZone, test.host), test.exposed)
+		})
+	}
+	assert.Assert(t, Zone{Name: "west"}.ExposesEndpointHost("east", "any.host"), "all hosts exposed by default")
+}
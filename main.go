@@ -6,9 +6,11 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/fgiorgetti/vanform/internal/metrics"
 	"github.com/fgiorgetti/vanform/internal/van"
 	"github.com/fgiorgetti/vanform/internal/van/kube"
 	"github.com/fgiorgetti/vanform/internal/van/system"
@@ -31,7 +33,14 @@ func main() {
 			os.Exit(1)
 		}
 	} else {
-		controller = system.NewController()
+		controller = system.NewController(cfg)
+	}
+	if cfg.MetricsAddress != "" {
+		go func() {
+			if err := metrics.ListenAndServe(cfg.MetricsAddress); err != nil {
+				log.Printf("Error serving metrics: %v", err)
+			}
+		}()
 	}
 	doneCh := controller.Start(stopCh)
 	handleShutdown(stopCh, doneCh)
@@ -68,6 +77,15 @@ func parseFlags() *van.ControllerConfig {
 	StringVar(flags, &c.Namespace, "namespace", "NAMESPACE", "", "The namespace scope for the controller")
 	StringVar(flags, &c.WatchNamespace, "watch-namespace", "WATCH_NAMESPACE", corev1.NamespaceAll, "The namespace the controller should monitor for controlled resources (will monitor all if not specified)")
 	StringVar(flags, &c.Kubeconfig, "kubeconfig", "KUBECONFIG", "", "A path to the kubeconfig file to use (kubernetes platform only")
+	DurationVar(flags, &c.ResyncPeriod, "resync-period", "RESYNC_PERIOD", time.Minute, "The interval of periodic reconciliations, besides the ones triggered by changes")
+	DurationVar(flags, &c.MaxRetryBackoff, "max-retry-backoff", "MAX_RETRY_BACKOFF", 15*time.Minute, "The maximum delay between retries of failed reconciliations (kubernetes platform only)")
+	IntVar(flags, &c.Workers, "workers", "WORKERS", 4, "The number of namespaces reconciled concurrently (kubernetes platform only)")
+	StringVar(flags, &c.MetricsAddress, "metrics-address", "METRICS_ADDRESS", "", "The address Prometheus metrics are served at, under /metrics, i.e. :9090 (disabled if empty)")
+	BoolVar(flags, &c.LeaderElect, "leader-elect", "LEADER_ELECT", false, "Enable leader election, so that a single replica is active at a time (kubernetes platform only)")
+	StringVar(flags, &c.LeaderElectNamespace, "leader-elect-namespace", "LEADER_ELECT_NAMESPACE", "", "The namespace of the leader election Lease (defaults to the controller's namespace)")
+	DurationVar(flags, &c.LeaderElectLeaseDuration, "leader-elect-lease-duration", "LEADER_ELECT_LEASE_DURATION", 15*time.Second, "The duration non-leader candidates wait before trying to acquire the leadership")
+	DurationVar(flags, &c.LeaderElectRenewDeadline, "leader-elect-renew-deadline", "LEADER_ELECT_RENEW_DEADLINE", 10*time.Second, "The duration the leader retries to renew the leadership before giving up")
+	DurationVar(flags, &c.LeaderElectRetryPeriod, "leader-elect-retry-period", "LEADER_ELECT_RETRY_PERIOD", 2*time.Second, "The duration candidates wait between attempts to acquire or renew the leadership")
 	isVersion := flags.Bool("version", false, "Report the version of the Skupper System Controller")
 	err := flags.Parse(os.Args[1:])
 	if err != nil {
@@ -85,9 +103,51 @@ func StringVar(flags *flag.FlagSet, output *string, flagName string, envVarName
 	flags.StringVar(output, flagName, stringEnvVar(envVarName, defaultValue), usage)
 }
 
+func DurationVar(flags *flag.FlagSet, output *time.Duration, flagName string, envVarName string, defaultValue time.Duration, usage string) {
+	flags.DurationVar(output, flagName, durationEnvVar(envVarName, defaultValue), usage)
+}
+
+func BoolVar(flags *flag.FlagSet, output *bool, flagName string, envVarName string, defaultValue bool, usage string) {
+	flags.BoolVar(output, flagName, boolEnvVar(envVarName, defaultValue), usage)
+}
+
+func IntVar(flags *flag.FlagSet, output *int, flagName string, envVarName string, defaultValue int, usage string) {
+	flags.IntVar(output, flagName, intEnvVar(envVarName, defaultValue), usage)
+}
+
 func stringEnvVar(name string, defaultValue string) string {
 	if value, ok := os.LookupEnv(name); ok {
 		return value
 	}
 	return defaultValue
 }
+
+func durationEnvVar(name string, defaultValue time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(name); ok {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+		log.Printf("Invalid duration for %s: %q, using default: %s", name, value, defaultValue)
+	}
+	return defaultValue
+}
+
+func intEnvVar(name string, defaultValue int) int {
+	if value, ok := os.LookupEnv(name); ok {
+		if intValue, err := strconv.Atoi(value); err == nil {
+			return intValue
+		}
+		log.Printf("Invalid integer for %s: %q, using default: %d", name, value, defaultValue)
+	}
+	return defaultValue
+}
+
+func boolEnvVar(name string, defaultValue bool) bool {
+	if value, ok := os.LookupEnv(name); ok {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+		log.Printf("Invalid boolean for %s: %q, using default: %t", name, value, defaultValue)
+	}
+	return defaultValue
+}